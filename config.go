@@ -3,7 +3,9 @@ package main
 import (
 	"fmt"
 	"log/slog"
+	"net/netip"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -35,14 +37,32 @@ func SetupLogging() {
 type AppConfig struct {
 	PPA ppa.Config
 
-	DiscordDownloadURL  string
-	DiscordPollInterval time.Duration
+	DiscordDownloadURL    string
+	DiscordPollInterval   time.Duration
+	DiscordSkipHostUpdate bool
 
-	PostmanDownloadURL  string
-	PostmanPollInterval time.Duration
+	DiscordPTBDownloadURL  string
+	DiscordPTBPollInterval time.Duration
+	DiscordPTBSuite        string
 
-	ZCLIGithubRepo   string
-	ZCLIPollInterval time.Duration
+	DiscordCanaryDownloadURL  string
+	DiscordCanaryPollInterval time.Duration
+	DiscordCanarySuite        string
+
+	DiscordVerification ppa.Verification // applies to every channel
+
+	PostmanArchitectures    []string
+	PostmanDownloadURL      string // amd64
+	PostmanARM64DownloadURL string
+	PostmanVersionURL       string // amd64
+	PostmanARM64VersionURL  string
+	PostmanPollInterval     time.Duration
+	PostmanVerification     ppa.Verification // applies to every architecture
+
+	ZCLIGithubRepo      string
+	ZCLIPollInterval    time.Duration
+	ZCLISigningKey      ppa.SignatureVerifier // nil if no key is pinned
+	ZCLIRequireChecksum bool
 }
 
 func LoadConfig() (*AppConfig, error) {
@@ -58,10 +78,25 @@ func LoadConfig() (*AppConfig, error) {
 			Origin:        getEnv("ORIGIN", "ppa.matejpavlicek.cz"),
 			Label:         getEnv("LABEL", "PPA"),
 			Maintainer:    getEnv("MAINTAINER", "PPA <ppa@matejpavlicek.cz>"),
+			APITokens:     getList("API_TOKENS", ""),
+
+			GitHubWebhookSecret: os.Getenv("GITHUB_WEBHOOK_SECRET"),
+			StatsSalt:           os.Getenv("STATS_SALT"),
+
+			PoolRedirect:   os.Getenv("POOL_REDIRECT"),
+			PoolCDNBaseURL: os.Getenv("POOL_CDN_BASE_URL"),
 		},
-		DiscordDownloadURL: getEnv("DISCORD_DOWNLOAD_URL", ""),
-		PostmanDownloadURL: getEnv("POSTMAN_DOWNLOAD_URL", ""),
-		ZCLIGithubRepo:     getEnv("ZCLI_GITHUB_REPO", "zeropsio/zcli"),
+		DiscordDownloadURL:       getEnv("DISCORD_DOWNLOAD_URL", ""),
+		DiscordPTBDownloadURL:    getEnv("DISCORD_PTB_DOWNLOAD_URL", ""),
+		DiscordPTBSuite:          getEnv("DISCORD_PTB_SUITE", "ptb"),
+		DiscordCanaryDownloadURL: getEnv("DISCORD_CANARY_DOWNLOAD_URL", ""),
+		DiscordCanarySuite:       getEnv("DISCORD_CANARY_SUITE", "canary"),
+		PostmanArchitectures:     getList("POSTMAN_ARCHITECTURES", "amd64,arm64"),
+		PostmanDownloadURL:       getEnv("POSTMAN_DOWNLOAD_URL", ""),
+		PostmanARM64DownloadURL:  getEnv("POSTMAN_ARM64_DOWNLOAD_URL", ""),
+		PostmanVersionURL:        getEnv("POSTMAN_VERSION_URL", ""),
+		PostmanARM64VersionURL:   getEnv("POSTMAN_ARM64_VERSION_URL", ""),
+		ZCLIGithubRepo:           getEnv("ZCLI_GITHUB_REPO", "zeropsio/zcli"),
 	}
 
 	var err error
@@ -71,6 +106,72 @@ func LoadConfig() (*AppConfig, error) {
 		return nil, err
 	}
 
+	cfg.PPA.AllowDowngrade, err = parseBool("ALLOW_DOWNGRADE", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.PPA.ReadyMaxAge, err = parseDuration("READY_MAX_AGE", "0")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.PPA.PoolPresignTTL, err = parseDuration("POOL_PRESIGN_TTL", "15m")
+	if err != nil {
+		return nil, err
+	}
+
+	cacheSizeMB, err := parseInt("CACHE_SIZE_MB", 64)
+	if err != nil {
+		return nil, err
+	}
+	cfg.PPA.CacheSize = cacheSizeMB * 1024 * 1024
+
+	cfg.PPA.CacheMetadataTTL, err = parseDuration("CACHE_METADATA_TTL", "1m")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.PPA.AccessLog, err = parseBool("ACCESS_LOG", true)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.PPA.AccessLogParseAPT, err = parseBool("ACCESS_LOG_PARSE_APT", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.PPA.TrustedProxies, err = parsePrefixes("TRUSTED_PROXIES", "127.0.0.0/8,::1/128")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.PPA.RateLimitMetadata, err = parseRateLimit("RATE_LIMIT_METADATA")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.PPA.RateLimitPool, err = parseRateLimit("RATE_LIMIT_POOL")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.DiscordSkipHostUpdate, err = parseBool("DISCORD_SKIP_HOST_UPDATE", false)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.DiscordPTBPollInterval, err = parseDuration("DISCORD_PTB_POLL_INTERVAL", "0")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.DiscordCanaryPollInterval, err = parseDuration("DISCORD_CANARY_POLL_INTERVAL", "0")
+	if err != nil {
+		return nil, err
+	}
+
 	cfg.PostmanPollInterval, err = parseDuration("POSTMAN_POLL_INTERVAL", "6h")
 	if err != nil {
 		return nil, err
@@ -81,6 +182,53 @@ func LoadConfig() (*AppConfig, error) {
 		return nil, err
 	}
 
+	cfg.DiscordVerification, err = parseVerification("DISCORD")
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.PostmanVerification, err = parseVerification("POSTMAN")
+	if err != nil {
+		return nil, err
+	}
+
+	if key := os.Getenv("ZCLI_SIGNING_KEY"); key != "" {
+		cfg.ZCLISigningKey, err = ppa.ParseSigningKey(key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ZCLI_SIGNING_KEY: %w", err)
+		}
+	}
+
+	cfg.ZCLIRequireChecksum, err = parseBool("ZCLI_REQUIRE_CHECKSUM", false)
+	if err != nil {
+		return nil, err
+	}
+
+	for envKey, suite := range map[string]string{
+		"DISCORD_PTB_SUITE":    cfg.DiscordPTBSuite,
+		"DISCORD_CANARY_SUITE": cfg.DiscordCanarySuite,
+	} {
+		if !ppa.ValidArchiveName(suite) {
+			return nil, fmt.Errorf("invalid %s %q: must match [a-z0-9][a-z0-9.+-]*", envKey, suite)
+		}
+	}
+
+	for _, arch := range cfg.PostmanArchitectures {
+		if arch != "amd64" && arch != "arm64" {
+			return nil, fmt.Errorf("invalid POSTMAN_ARCHITECTURES entry %q: must be amd64 or arm64", arch)
+		}
+	}
+
+	switch cfg.PPA.PoolRedirect {
+	case "", "presign":
+	case "cdn":
+		if cfg.PPA.PoolCDNBaseURL == "" {
+			return nil, fmt.Errorf("POOL_CDN_BASE_URL is required when POOL_REDIRECT is cdn")
+		}
+	default:
+		return nil, fmt.Errorf("invalid POOL_REDIRECT %q: must be presign or cdn", cfg.PPA.PoolRedirect)
+	}
+
 	if cfg.PPA.GPGPrivateKey == "" {
 		return nil, fmt.Errorf("GPG_PRIVATE_KEY is required")
 	}
@@ -107,6 +255,17 @@ func getEnv(key, fallback string) string {
 	return fallback
 }
 
+// getList splits a comma-separated variable, dropping empty items.
+func getList(key, fallback string) []string {
+	var items []string
+	for _, item := range strings.Split(getEnv(key, fallback), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func parseDuration(envKey, fallback string) (time.Duration, error) {
 	raw := getEnv(envKey, fallback)
 	d, err := time.ParseDuration(raw)
@@ -115,3 +274,92 @@ func parseDuration(envKey, fallback string) (time.Duration, error) {
 	}
 	return d, nil
 }
+
+func parseBool(envKey string, fallback bool) (bool, error) {
+	raw := os.Getenv(envKey)
+	if raw == "" {
+		return fallback, nil
+	}
+	b, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: %w", envKey, raw, err)
+	}
+	return b, nil
+}
+
+func parseInt(envKey string, fallback int64) (int64, error) {
+	raw := os.Getenv(envKey)
+	if raw == "" {
+		return fallback, nil
+	}
+	n, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a non-negative integer", envKey, raw)
+	}
+	return n, nil
+}
+
+// parseRateLimit parses "<requests>/<duration>", e.g. "60/1m". Empty or "0"
+// disables the limit.
+func parseRateLimit(envKey string) (ppa.RateLimit, error) {
+	raw := os.Getenv(envKey)
+	if raw == "" || raw == "0" {
+		return ppa.RateLimit{}, nil
+	}
+	requests, per, ok := strings.Cut(raw, "/")
+	n, err := strconv.ParseInt(requests, 10, 64)
+	if !ok || err != nil || n <= 0 {
+		return ppa.RateLimit{}, fmt.Errorf("invalid %s %q: must be <requests>/<duration>, e.g. 60/1m", envKey, raw)
+	}
+	d, err := time.ParseDuration(per)
+	if err != nil || d <= 0 {
+		return ppa.RateLimit{}, fmt.Errorf("invalid %s %q: must be <requests>/<duration>, e.g. 60/1m", envKey, raw)
+	}
+	return ppa.RateLimit{Requests: n, Per: d}, nil
+}
+
+// parseVerification reads the <prefix>_SHA256SUMS_URL, <prefix>_SIGNING_KEY
+// and <prefix>_SIGNATURE_URL variables describing how a source's upstream
+// artifacts are verified.
+func parseVerification(prefix string) (ppa.Verification, error) {
+	v := ppa.Verification{
+		SHA256SumsURL: os.Getenv(prefix + "_SHA256SUMS_URL"),
+		SignatureURL:  os.Getenv(prefix + "_SIGNATURE_URL"),
+	}
+	if key := os.Getenv(prefix + "_SIGNING_KEY"); key != "" {
+		var err error
+		v.SigningKey, err = ppa.ParseSigningKey(key)
+		if err != nil {
+			return v, fmt.Errorf("invalid %s_SIGNING_KEY: %w", prefix, err)
+		}
+		if v.SignatureURL == "" {
+			return v, fmt.Errorf("%s_SIGNATURE_URL is required when %s_SIGNING_KEY is set", prefix, prefix)
+		}
+	}
+	return v, nil
+}
+
+// parsePrefixes parses a comma-separated list of CIDR prefixes or bare
+// addresses. "none" is an empty list.
+func parsePrefixes(envKey, fallback string) ([]netip.Prefix, error) {
+	if getEnv(envKey, fallback) == "none" {
+		return nil, nil
+	}
+	var prefixes []netip.Prefix
+	for _, item := range getList(envKey, fallback) {
+		if !strings.Contains(item, "/") {
+			addr, err := netip.ParseAddr(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s entry %q: %w", envKey, item, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s entry %q: %w", envKey, item, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -5,31 +5,99 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 
 	"github.com/tikinang/discord-ppa/ppa"
 )
 
-const defaultDiscordDownloadURL = "https://discord.com/api/download?platform=linux&format=deb"
+// DiscordChannel selects the Discord release channel a source tracks.
+type DiscordChannel string
+
+const (
+	DiscordStable DiscordChannel = "stable"
+	DiscordPTB    DiscordChannel = "ptb"
+	DiscordCanary DiscordChannel = "canary"
+)
+
+var defaultDiscordDownloadURLs = map[DiscordChannel]string{
+	DiscordStable: "https://discord.com/api/download?platform=linux&format=deb",
+	DiscordPTB:    "https://discord.com/api/download/ptb?platform=linux&format=deb",
+	DiscordCanary: "https://discord.com/api/download/canary?platform=linux&format=deb",
+}
+
+// discordConfigDirs are the per-user settings directories under ~/.config
+// used by each channel.
+var discordConfigDirs = map[DiscordChannel]string{
+	DiscordStable: "discord",
+	DiscordPTB:    "discordptb",
+	DiscordCanary: "discordcanary",
+}
+
+// skipHostUpdatePostinst sets SKIP_HOST_UPDATE in every existing settings.json
+// so the client stops refusing to start until a newer .deb is installed
+// manually. Users who never launched Discord have no settings file yet; it is
+// patched on the next upgrade.
+const skipHostUpdatePostinst = `
+# Let apt deliver updates instead of Discord's built-in host updater.
+if command -v python3 >/dev/null 2>&1; then
+	for settings in /root/.config/%[1]s/settings.json /home/*/.config/%[1]s/settings.json; do
+		[ -f "$settings" ] || continue
+		python3 - "$settings" <<'EOF' || true
+import json, sys
+path = sys.argv[1]
+with open(path) as f:
+    settings = json.load(f)
+settings["SKIP_HOST_UPDATE"] = True
+with open(path, "w") as f:
+    json.dump(settings, f, indent=2)
+EOF
+	done
+fi
+`
+
+// discordVersionPattern extracts the version from the file name the download
+// API redirects to, e.g. "discord-0.0.76.deb".
+var discordVersionPattern = regexp.MustCompile(`^discord[a-z-]*-(\d+(?:\.\d+)+)\.deb$`)
 
 type DiscordSource struct {
-	downloadURL string
+	channel        DiscordChannel
+	downloadURL    string
+	skipHostUpdate bool
 }
 
-func NewDiscordSource(downloadURL string) *DiscordSource {
+func NewDiscordSource(channel DiscordChannel, downloadURL string, skipHostUpdate bool) *DiscordSource {
 	if downloadURL == "" {
-		downloadURL = defaultDiscordDownloadURL
+		downloadURL = defaultDiscordDownloadURLs[channel]
 	}
-	return &DiscordSource{downloadURL: downloadURL}
+	return &DiscordSource{channel: channel, downloadURL: downloadURL, skipHostUpdate: skipHostUpdate}
 }
 
+// Name matches the package name Discord uses for the channel:
+// "discord", "discord-ptb" or "discord-canary".
 func (d *DiscordSource) Name() string {
-	return "discord"
+	if d.channel == DiscordStable {
+		return "discord"
+	}
+	return "discord-" + string(d.channel)
 }
 
 func (d *DiscordSource) Description() string {
-	return "Discord voice and text chat client. The official .deb is fetched directly from Discord's download API. New versions are detected via ETag changes on the download URL."
+	desc := "Discord voice and text chat client. "
+	switch d.channel {
+	case DiscordPTB:
+		desc = "Discord Public Test Build, previewing features ahead of the stable client. "
+	case DiscordCanary:
+		desc = "Discord Canary, the alpha testing build receiving the newest and least tested features. "
+	}
+	desc += "The official .deb is fetched directly from Discord's download API. New versions are detected from the versioned file name the download API redirects to."
+	if d.skipHostUpdate {
+		desc += " A post-install step disables Discord's built-in updater so upgrades come through apt; the version carries a +ppa1 suffix."
+	}
+	return desc
 }
 
+// Check follows the download redirect and uses the version parsed from the
+// target file name as state, falling back to ETag when it has no version.
 func (d *DiscordSource) Check(ctx context.Context) (string, error) {
 	resp, err := ppa.HTTPWithRetry(ctx, d.downloadURL, "HEAD")
 	if err != nil {
@@ -41,6 +109,10 @@ func (d *DiscordSource) Check(ctx context.Context) (string, error) {
 		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
 	}
 
+	if m := discordVersionPattern.FindStringSubmatch(ppa.ResponseFilename(resp)); m != nil {
+		return m[1], nil
+	}
+
 	etag := resp.Header.Get("ETag")
 	if etag == "" {
 		etag = resp.Header.Get("Content-Length")
@@ -48,6 +120,9 @@ func (d *DiscordSource) Check(ctx context.Context) (string, error) {
 	return etag, nil
 }
 
+// Fetch downloads the .deb. Discord publishes no checksums or signatures,
+// so it can only be verified against a Verification configured for the
+// source.
 func (d *DiscordSource) Fetch(ctx context.Context) ([]byte, error) {
 	resp, err := ppa.HTTPWithRetry(ctx, d.downloadURL, "GET")
 	if err != nil {
@@ -63,5 +138,18 @@ func (d *DiscordSource) Fetch(ctx context.Context) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("reading .deb: %w", err)
 	}
+	ppa.ReportUnverified(ctx, "Discord publishes no checksum or signature for its .deb")
 	return data, nil
 }
+
+// Transforms returns the modifications applied to the upstream .deb before
+// publishing: the SKIP_HOST_UPDATE postinst step and a +ppa1 version suffix.
+func (d *DiscordSource) Transforms() []ppa.Transform {
+	if !d.skipHostUpdate {
+		return nil
+	}
+	return []ppa.Transform{
+		ppa.PrependScript("postinst", fmt.Sprintf(skipHostUpdatePostinst, discordConfigDirs[d.channel])),
+		ppa.VersionSuffix("+ppa1"),
+	}
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log/slog"
 	"os"
@@ -42,29 +43,96 @@ func main() {
 				}
 			}
 			return
+		case "publish":
+			fs := flag.NewFlagSet("publish", flag.ExitOnError)
+			suite := fs.String("suite", "stable", "APT suite to publish to")
+			component := fs.String("component", "main", "APT component to publish to")
+			force := fs.Bool("force", false, "publish even if a polled source publishes the same package")
+			fs.Parse(os.Args[2:])
+			// Accept flags after the file name too
+			var file string
+			if fs.NArg() > 0 {
+				file = fs.Arg(0)
+				fs.Parse(fs.Args()[1:])
+			}
+			if file == "" || fs.NArg() > 0 {
+				fmt.Fprintf(os.Stderr, "Usage: %s publish <file.deb> [--suite <suite>] [--component <component>] [--force]\n", os.Args[0])
+				os.Exit(1)
+			}
+			data, err := os.ReadFile(file)
+			if err != nil {
+				slog.Error("Error reading package", "file", file, "error", err)
+				os.Exit(1)
+			}
+			ctrl, err := p.PublishDeb(context.Background(), data, *suite, *component, *force)
+			if err != nil {
+				slog.Error("Error publishing package", "file", file, "error", err)
+				os.Exit(1)
+			}
+			fmt.Printf("Published %s %s (%s) to %s/%s\n", ctrl.Package, ctrl.Version, ctrl.Architecture, *suite, *component)
+			return
+		case "migrate-pool":
+			deleteOld := len(os.Args) > 2 && os.Args[2] == "--delete-old"
+			if err := p.MigratePool(context.Background(), deleteOld); err != nil {
+				slog.Error("Error migrating pool", "error", err)
+				os.Exit(1)
+			}
+			return
 		default:
-			fmt.Fprintf(os.Stderr, "Unknown command: %s\nUsage: %s [delete <source-name>]\n", os.Args[1], os.Args[0])
+			fmt.Fprintf(os.Stderr, "Unknown command: %s\nUsage: %s [delete <source-name> | publish <file.deb> | migrate-pool [--delete-old]]\n", os.Args[1], os.Args[0])
 			os.Exit(1)
 		}
 	}
 
 	if cfg.DiscordPollInterval > 0 {
+		discord := NewDiscordSource(DiscordStable, cfg.DiscordDownloadURL, cfg.DiscordSkipHostUpdate)
 		p.Register(ppa.SourceRegistration{
-			Source:       NewDiscordSource(cfg.DiscordDownloadURL),
+			Source:       discord,
 			PollInterval: cfg.DiscordPollInterval,
+			Transforms:   discord.Transforms(),
+			Verification: cfg.DiscordVerification,
 		})
 	}
 
-	if cfg.PostmanPollInterval > 0 {
+	if cfg.DiscordPTBPollInterval > 0 {
+		ptb := NewDiscordSource(DiscordPTB, cfg.DiscordPTBDownloadURL, cfg.DiscordSkipHostUpdate)
+		p.Register(ppa.SourceRegistration{
+			Source:       ptb,
+			PollInterval: cfg.DiscordPTBPollInterval,
+			Suite:        cfg.DiscordPTBSuite,
+			Transforms:   ptb.Transforms(),
+			Verification: cfg.DiscordVerification,
+		})
+	}
+
+	if cfg.DiscordCanaryPollInterval > 0 {
+		canary := NewDiscordSource(DiscordCanary, cfg.DiscordCanaryDownloadURL, cfg.DiscordSkipHostUpdate)
 		p.Register(ppa.SourceRegistration{
-			Source:       NewPostmanSource(cfg.PostmanDownloadURL, cfg.PPA.Maintainer),
-			PollInterval: cfg.PostmanPollInterval,
+			Source:       canary,
+			PollInterval: cfg.DiscordCanaryPollInterval,
+			Suite:        cfg.DiscordCanarySuite,
+			Transforms:   canary.Transforms(),
+			Verification: cfg.DiscordVerification,
 		})
 	}
 
+	if cfg.PostmanPollInterval > 0 {
+		for _, arch := range cfg.PostmanArchitectures {
+			downloadURL, versionURL := cfg.PostmanDownloadURL, cfg.PostmanVersionURL
+			if arch == "arm64" {
+				downloadURL, versionURL = cfg.PostmanARM64DownloadURL, cfg.PostmanARM64VersionURL
+			}
+			p.Register(ppa.SourceRegistration{
+				Source:       NewPostmanSource(arch, downloadURL, versionURL, cfg.PPA.Maintainer),
+				PollInterval: cfg.PostmanPollInterval,
+				Verification: cfg.PostmanVerification,
+			})
+		}
+	}
+
 	if cfg.ZCLIGithubRepo != "" && cfg.ZCLIPollInterval > 0 {
 		p.Register(ppa.SourceRegistration{
-			Source:       NewZCLISource(cfg.ZCLIGithubRepo),
+			Source:       NewZCLISource(cfg.ZCLIGithubRepo, cfg.ZCLISigningKey, cfg.ZCLIRequireChecksum),
 			PollInterval: cfg.ZCLIPollInterval,
 		})
 	}
@@ -8,36 +8,91 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/tikinang/discord-ppa/ppa"
 )
 
-const defaultPostmanDownloadURL = "https://dl.pstmn.io/download/latest/linux64"
+// defaultPostmanDownloadURLs maps Debian architectures to Postman's tarball URLs.
+var defaultPostmanDownloadURLs = map[string]string{
+	"amd64": "https://dl.pstmn.io/download/latest/linux64",
+	"arm64": "https://dl.pstmn.io/download/latest/linuxarm64",
+}
+
+// defaultPostmanVersionURLs maps Debian architectures to the update
+// endpoint the Postman app polls, which answers with the latest version as
+// JSON, e.g. {"version": "11.23.3", ...}.
+var defaultPostmanVersionURLs = map[string]string{
+	"amd64": "https://dl.pstmn.io/update/status?currentVersion=0.0.0&platform=linux64",
+	"arm64": "https://dl.pstmn.io/update/status?currentVersion=0.0.0&platform=linuxarm64",
+}
+
+// postmanVersionPattern extracts the version from the tarball file name
+// announced by the download endpoint, e.g. "Postman-linux-x64-11.23.3.tar.gz".
+var postmanVersionPattern = regexp.MustCompile(`-(\d+(?:\.\d+)+)\.tar\.gz$`)
 
+// postmanVersion matches a version reported by the update endpoint.
+var postmanVersion = regexp.MustCompile(`^\d+(?:\.\d+)+$`)
+
+// PostmanSource repackages the Postman tarball of one architecture. Each
+// architecture is registered as its own source so it is polled and tracked
+// independently.
 type PostmanSource struct {
+	arch        string
 	downloadURL string
+	versionURL  string
 	maintainer  string
 }
 
-func NewPostmanSource(downloadURL, maintainer string) *PostmanSource {
+func NewPostmanSource(arch, downloadURL, versionURL, maintainer string) *PostmanSource {
 	if downloadURL == "" {
-		downloadURL = defaultPostmanDownloadURL
+		downloadURL = defaultPostmanDownloadURLs[arch]
+	}
+	if versionURL == "" {
+		versionURL = defaultPostmanVersionURLs[arch]
 	}
-	return &PostmanSource{downloadURL: downloadURL, maintainer: maintainer}
+	return &PostmanSource{arch: arch, downloadURL: downloadURL, versionURL: versionURL, maintainer: maintainer}
 }
 
+// Name is "postman" for amd64, keeping the original state keys, and
+// "postman-<arch>" for other architectures.
 func (p *PostmanSource) Name() string {
-	return "postman"
+	if p.arch == "amd64" {
+		return "postman"
+	}
+	return "postman-" + p.arch
 }
 
 func (p *PostmanSource) Description() string {
-	return "Postman API development environment. Downloaded as a tar.gz from dl.pstmn.io, extracted, and repackaged into a .deb with a desktop entry and /usr/bin/postman symlink. Version is read from the embedded package.json."
+	return "Postman API development environment (" + p.arch + "). Downloaded as a tar.gz from dl.pstmn.io, extracted, and repackaged into a .deb with a desktop entry and /usr/bin/postman symlink. New versions are detected from Postman's update endpoint; the package version is read from the embedded package.json."
 }
 
+// Check uses the latest version reported by Postman's update endpoint as
+// state, so CDN-side ETag changes don't trigger a full download. If the
+// endpoint fails, it falls back to the version in the tarball file name
+// (Content-Disposition or redirect target). The state is always a version,
+// so switching between the two doesn't look like a new release.
 func (p *PostmanSource) Check(ctx context.Context) (string, error) {
+	version, err := p.latestVersion(ctx)
+	if err == nil {
+		return version, nil
+	}
+	slog.Warn("Postman version endpoint failed, falling back to the download URL", "source", p.Name(), "error", err)
+
+	version, fallbackErr := p.filenameVersion(ctx)
+	if fallbackErr != nil {
+		return "", fmt.Errorf("version endpoint: %w; download URL: %w", err, fallbackErr)
+	}
+	return version, nil
+}
+
+// filenameVersion reads the version from the tarball file name announced
+// by the download URL.
+func (p *PostmanSource) filenameVersion(ctx context.Context) (string, error) {
 	resp, err := ppa.HTTPWithRetry(ctx, p.downloadURL, "HEAD")
 	if err != nil {
 		return "", fmt.Errorf("HEAD request failed: %w", err)
@@ -48,13 +103,41 @@ func (p *PostmanSource) Check(ctx context.Context) (string, error) {
 		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
 	}
 
-	etag := resp.Header.Get("ETag")
-	if etag == "" {
-		etag = resp.Header.Get("Content-Length")
+	filename := ppa.ResponseFilename(resp)
+	m := postmanVersionPattern.FindStringSubmatch(filename)
+	if m == nil {
+		return "", fmt.Errorf("no version in file name %q", filename)
+	}
+	return m[1], nil
+}
+
+// latestVersion asks the update endpoint for the latest version.
+func (p *PostmanSource) latestVersion(ctx context.Context) (string, error) {
+	resp, err := ppa.HTTPWithRetry(ctx, p.versionURL, "GET")
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+
+	var update struct {
+		Version string `json:"version"`
 	}
-	return etag, nil
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1024*1024)).Decode(&update); err != nil {
+		return "", fmt.Errorf("decoding response: %w", err)
+	}
+	if !postmanVersion.MatchString(update.Version) {
+		return "", fmt.Errorf("unexpected version %q", update.Version)
+	}
+	return update.Version, nil
 }
 
+// Fetch downloads the tarball, which Build repackages. Postman publishes no
+// checksums or signatures, so the tarball can only be verified against a
+// Verification configured for the source.
 func (p *PostmanSource) Fetch(ctx context.Context) ([]byte, error) {
 	resp, err := ppa.HTTPWithRetry(ctx, p.downloadURL, "GET")
 	if err != nil {
@@ -70,8 +153,13 @@ func (p *PostmanSource) Fetch(ctx context.Context) ([]byte, error) {
 	if err != nil {
 		return nil, fmt.Errorf("reading tar.gz: %w", err)
 	}
+	ppa.ReportUnverified(ctx, "Postman publishes no checksum or signature for its tarball")
+	return tarData, nil
+}
 
-	return p.buildDeb(tarData)
+// Build repackages the tarball returned by Fetch as a .deb.
+func (p *PostmanSource) Build(tarGzData []byte) ([]byte, error) {
+	return p.buildDeb(tarGzData)
 }
 
 type postmanEntry struct {
@@ -151,7 +239,7 @@ StartupWMClass=postman
 	ctrl := ppa.DebControl{
 		Package:      "postman",
 		Version:      version,
-		Architecture: "amd64",
+		Architecture: p.arch,
 		Maintainer:   p.maintainer,
 		Description:  "Postman - API Development Environment",
 		Section:      "devel",
@@ -160,7 +248,7 @@ StartupWMClass=postman
 		Fields: []ppa.ControlField{
 			{Key: "Package", Value: "postman"},
 			{Key: "Version", Value: version},
-			{Key: "Architecture", Value: "amd64"},
+			{Key: "Architecture", Value: p.arch},
 			{Key: "Installed-Size", Value: installedSize},
 			{Key: "Maintainer", Value: p.maintainer},
 			{Key: "Homepage", Value: "https://www.postman.com"},
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// postmanServer serves the update endpoint at /update and the tarball
+// download at /download.
+func postmanServer(t *testing.T, update func(w http.ResponseWriter), filename string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/update":
+			update(w)
+		case "/download":
+			if filename != "" {
+				w.Header().Set("Content-Disposition", "attachment; filename="+filename)
+			}
+			w.Header().Set("ETag", `"changes-every-time"`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestPostmanCheckVersionEndpoint(t *testing.T) {
+	response, err := os.ReadFile("testdata/postman-update-status.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := postmanServer(t, func(w http.ResponseWriter) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(response)
+	}, "Postman-linux-x64-11.22.0.tar.gz")
+
+	source := NewPostmanSource("amd64", srv.URL+"/download", srv.URL+"/update", "")
+	state, err := source.Check(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state != "11.23.3" {
+		t.Errorf("state = %q, want 11.23.3", state)
+	}
+}
+
+func TestPostmanCheckFallback(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   func(w http.ResponseWriter)
+		filename string
+		want     string // "" if Check must fail
+	}{
+		{
+			name:     "endpoint down",
+			update:   func(w http.ResponseWriter) { http.Error(w, "unavailable", http.StatusServiceUnavailable) },
+			filename: "Postman-linux-x64-11.23.3.tar.gz",
+			want:     "11.23.3",
+		},
+		{
+			name:     "no version in response",
+			update:   func(w http.ResponseWriter) { w.Write([]byte(`{"url": "https://dl.pstmn.io/download/latest/linux64"}`)) },
+			filename: "Postman-linux-x64-11.23.3.tar.gz",
+			want:     "11.23.3",
+		},
+		{
+			name:     "malformed version",
+			update:   func(w http.ResponseWriter) { w.Write([]byte(`{"version": "latest"}`)) },
+			filename: "Postman-linux-x64-11.23.3.tar.gz",
+			want:     "11.23.3",
+		},
+		{
+			name:   "no version anywhere",
+			update: func(w http.ResponseWriter) { w.Write([]byte(`not json`)) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := postmanServer(t, tt.update, tt.filename)
+			source := NewPostmanSource("amd64", srv.URL+"/download", srv.URL+"/update", "")
+			state, err := source.Check(context.Background())
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("Check returned state %q, want an error", state)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state != tt.want {
+				t.Errorf("state = %q, want %q", state, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package ppa
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// accessLog writes one JSON record per request to stdout, separate from the
+// application log.
+func (s *server) accessLog(next http.Handler) http.Handler {
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, nil))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		attrs := []slog.Attr{
+			slog.String("method", r.Method),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int64("bytes", rec.bytes),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", s.clientIP(r).String()),
+			slog.String("user_agent", r.UserAgent()),
+		}
+		if r.Header.Get("Range") != "" {
+			attrs = append(attrs, slog.String("range", r.Header.Get("Range")))
+		}
+		if s.accessLogParseAPT {
+			if apt, ok := parseAPTUserAgent(r.UserAgent()); ok {
+				attrs = append(attrs, slog.Group("apt",
+					slog.String("version", apt.version),
+					slog.String("distro", apt.distro),
+				))
+			}
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "HTTP request", attrs...)
+	})
+}
+
+// clientIP returns the address of the client, following X-Forwarded-For
+// through trusted proxies. Entries are read right to left, and the first
+// address not belonging to a trusted proxy is the client.
+func (s *server) clientIP(r *http.Request) netip.Addr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	addr = addr.Unmap()
+
+	var forwarded []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		forwarded = append(forwarded, strings.Split(header, ",")...)
+	}
+	for _, hop := range slices.Backward(forwarded) {
+		if !s.trustedProxy(addr) {
+			break
+		}
+		next, err := netip.ParseAddr(strings.TrimSpace(hop))
+		if err != nil {
+			break
+		}
+		addr = next.Unmap()
+	}
+	return addr
+}
+
+func (s *server) trustedProxy(addr netip.Addr) bool {
+	for _, prefix := range s.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// aptUserAgent matches apt's User-Agent, e.g.
+// "Debian APT-HTTP/1.3 (2.6.1)" or "Debian APT-HTTP/1.3 (2.7.14build2) non-interactive".
+var aptUserAgent = regexp.MustCompile(`^(\S+) APT-HTTP/[0-9.]+ \(([^)\s]+)\)`)
+
+type aptClient struct {
+	version string
+	distro  string
+}
+
+// aptReleases maps apt major.minor versions to the release shipping them.
+// apt reports its vendor as "Debian" on Ubuntu too, so this is the only
+// hint of the distribution.
+var aptReleases = map[string]string{
+	"1.6": "ubuntu 18.04",
+	"1.8": "debian 10",
+	"2.0": "ubuntu 20.04",
+	"2.2": "debian 11",
+	"2.4": "ubuntu 22.04",
+	"2.6": "debian 12",
+	"2.7": "ubuntu 24.04",
+	"3.0": "debian 13",
+}
+
+// parseAPTUserAgent extracts the apt version from apt's User-Agent and
+// guesses the distribution from it, falling back to the reported vendor.
+func parseAPTUserAgent(ua string) (aptClient, bool) {
+	m := aptUserAgent.FindStringSubmatch(ua)
+	if m == nil {
+		return aptClient{}, false
+	}
+	client := aptClient{version: m[2], distro: strings.ToLower(m[1])}
+	if major, rest, ok := strings.Cut(m[2], "."); ok {
+		minor, _, _ := strings.Cut(rest, ".")
+		if release, ok := aptReleases[major+"."+minor]; ok {
+			client.distro = release
+		}
+	}
+	return client, true
+}
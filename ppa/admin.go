@@ -0,0 +1,218 @@
+package ppa
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+)
+
+var (
+	errUnknownSource  = errors.New("unknown source")
+	errUnknownVersion = errors.New("version not found in history")
+)
+
+// sourceSummary describes a registered or previously published source for
+// the admin API.
+type sourceSummary struct {
+	Name         string   `json:"name"`
+	Registered   bool     `json:"registered"`
+	Suite        string   `json:"suite,omitempty"`
+	Component    string   `json:"component,omitempty"`
+	PollInterval string   `json:"poll_interval,omitempty"`
+	State        string   `json:"state,omitempty"`
+	Version      string   `json:"version,omitempty"`
+	History      []string `json:"history,omitempty"` // newest first
+
+	Status *sourceStatus `json:"status,omitempty"`
+}
+
+// metaSourceName returns the source a meta/ key belongs to, e.g. "discord"
+// for "meta/discord/state" and "meta/discord/history/1.0".
+func metaSourceName(key string) string {
+	key = strings.TrimPrefix(key, "meta/")
+	if prefix, _, ok := strings.Cut(key, "/history/"); ok {
+		return prefix
+	}
+	if i := strings.LastIndex(key, "/"); i >= 0 {
+		return key[:i]
+	}
+	return key
+}
+
+// listSources summarizes registered sources and every source with metadata
+// in the bucket, such as uploads and sources no longer registered.
+func (p *PPA) listSources(ctx context.Context) ([]sourceSummary, error) {
+	keys, err := p.s3.ListPrefix(ctx, "meta/")
+	if err != nil {
+		return nil, fmt.Errorf("listing meta entries: %w", err)
+	}
+
+	summaries := map[string]*sourceSummary{}
+	var names []string
+	summary := func(name string) *sourceSummary {
+		if summaries[name] == nil {
+			summaries[name] = &sourceSummary{Name: name}
+			names = append(names, name)
+		}
+		return summaries[name]
+	}
+
+	for _, reg := range p.sources {
+		s := summary(reg.Source.Name())
+		s.Registered = true
+		s.Suite = reg.suite()
+		s.PollInterval = reg.PollInterval.String()
+		if status, ok := p.sourceStatusOf(reg.Source.Name()); ok {
+			s.Status = &status
+		}
+	}
+
+	for _, key := range keys {
+		s := summary(metaSourceName(key))
+		if _, version, ok := strings.Cut(key, "/history/"); ok {
+			s.History = append(s.History, version)
+			continue
+		}
+
+		switch key[strings.LastIndex(key, "/")+1:] {
+		case "state":
+			if data, err := p.s3.Download(ctx, key); err == nil {
+				s.State = string(data)
+			}
+		case "suite":
+			if data, err := p.s3.Download(ctx, key); err == nil && len(data) > 0 {
+				s.Suite = string(data)
+			}
+		case "component":
+			if data, err := p.s3.Download(ctx, key); err == nil && len(data) > 0 {
+				s.Component = string(data)
+			}
+		case "packages-entry":
+			if data, err := p.s3.Download(ctx, key); err == nil {
+				s.Version = StanzaField(string(data), "Version")
+			}
+		}
+	}
+
+	result := make([]sourceSummary, 0, len(names))
+	for _, name := range names {
+		s := summaries[name]
+		slices.SortFunc(s.History, func(a, b string) int { return CompareVersions(b, a) })
+		result = append(result, *s)
+	}
+	return result, nil
+}
+
+// triggerPoll asks a registered source's poller to check upstream now. A
+// trigger is dropped if one is already pending.
+func (p *PPA) triggerPoll(sourceName string) error {
+	trigger, ok := p.triggers[sourceName]
+	if !ok {
+		return fmt.Errorf("%s: %w", sourceName, errUnknownSource)
+	}
+	select {
+	case trigger <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// triggerGitHubPolls triggers every registered source tracking the given
+// GitHub repository and returns their names.
+func (p *PPA) triggerGitHubPolls(repo string) []string {
+	var triggered []string
+	for _, reg := range p.sources {
+		gh, ok := reg.Source.(GitHubSource)
+		if !ok || !strings.EqualFold(gh.GitHubRepo(), repo) {
+			continue
+		}
+		if err := p.triggerPoll(gh.Name()); err == nil {
+			triggered = append(triggered, gh.Name())
+		}
+	}
+	return triggered
+}
+
+// Rollback republishes an earlier version of a source from its history. An
+// empty version selects the newest version older than the current one. The
+// upstream state is kept, so the poller does not republish the version that
+// was rolled back until upstream changes again.
+func (p *PPA) Rollback(ctx context.Context, sourceName, version string) (string, error) {
+	prefix := "meta/" + sourceName + "/"
+
+	// Hold the lock publish takes, so a concurrent poll can't replace the
+	// packages entry between checking and rewriting it.
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	current, err := p.s3.Download(ctx, prefix+"packages-entry")
+	if isNotFound(err) {
+		return "", fmt.Errorf("%s: %w", sourceName, errUnknownSource)
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading current packages entry: %w", err)
+	}
+	currentVersion := StanzaField(string(current), "Version")
+
+	keys, err := p.s3.ListPrefix(ctx, prefix+"history/")
+	if err != nil {
+		return "", fmt.Errorf("listing history: %w", err)
+	}
+	var history []string
+	for _, key := range keys {
+		history = append(history, strings.TrimPrefix(key, prefix+"history/"))
+	}
+
+	if version == "" {
+		for _, v := range history {
+			if CompareVersions(v, currentVersion) < 0 && (version == "" || CompareVersions(v, version) > 0) {
+				version = v
+			}
+		}
+		if version == "" {
+			return "", fmt.Errorf("%s: no version older than %s: %w", sourceName, currentVersion, errUnknownVersion)
+		}
+	} else if !slices.Contains(history, version) {
+		return "", fmt.Errorf("%s %s: %w", sourceName, version, errUnknownVersion)
+	}
+
+	entry, err := p.s3.Download(ctx, prefix+"history/"+version)
+	if err != nil {
+		return "", fmt.Errorf("reading history entry: %w", err)
+	}
+	for _, stanza := range SplitStanzas(string(entry)) {
+		filename := StanzaField(stanza, "Filename")
+		exists, err := p.s3.Exists(ctx, filename)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", fmt.Errorf("pool file %s of %s %s no longer exists", filename, sourceName, version)
+		}
+	}
+
+	slog.Info("Rolling back source", "source", sourceName, "from", currentVersion, "to", version)
+	if err := p.s3.Upload(ctx, prefix+"packages-entry", entry, "text/plain"); err != nil {
+		return "", fmt.Errorf("uploading packages-entry: %w", err)
+	}
+
+	if err := p.regenerateRepoMetadata(ctx); err != nil {
+		return "", fmt.Errorf("regenerating repo metadata: %w", err)
+	}
+	return version, nil
+}
+
+// Regenerate rebuilds and re-signs all repo metadata from the stored
+// packages entries.
+func (p *PPA) Regenerate(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.regenerateRepoMetadata(ctx); err != nil {
+		return fmt.Errorf("regenerating repo metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,240 @@
+package ppa
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// requireToken guards an /api/ handler with bearer token authentication.
+// Without configured tokens the API is disabled.
+func (s *server) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.apiTokens) == 0 {
+			http.Error(w, "Not Found", http.StatusNotFound)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || !s.validToken(token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="ppa"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *server) validToken(token string) bool {
+	valid := false
+	for _, t := range s.apiTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// handlePublish accepts a raw .deb request body and publishes it to the
+// suite and component given as query parameters (default stable/main).
+// force=true publishes packages a polled source also publishes.
+func (s *server) handlePublish(w http.ResponseWriter, r *http.Request) {
+	// Uploads of large packages outlast the server-wide read timeout.
+	http.NewResponseController(w).SetReadDeadline(time.Now().Add(10 * time.Minute))
+
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxDebSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, err)
+		} else {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("reading request body: %w", err))
+		}
+		return
+	}
+
+	query := r.URL.Query()
+	force, _ := strconv.ParseBool(query.Get("force"))
+	ctrl, err := s.ppa.PublishDeb(r.Context(), data, query.Get("suite"), query.Get("component"), force)
+	if err != nil {
+		var invalid *InvalidPackageError
+		switch {
+		case errors.As(err, &invalid):
+			slog.Warn("Package upload rejected", "error", err)
+			writeJSONError(w, http.StatusUnprocessableEntity, err)
+		case errors.Is(err, errPoolConflict), errors.Is(err, errPackageOwned):
+			slog.Warn("Package upload rejected", "error", err)
+			writeJSONError(w, http.StatusConflict, err)
+		default:
+			slog.Error("Package upload failed", "error", err)
+			writeJSONError(w, http.StatusInternalServerError, err)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{
+		"package":      ctrl.Package,
+		"version":      ctrl.Version,
+		"architecture": ctrl.Architecture,
+	})
+}
+
+// handleListSources lists sources with their upstream state, published
+// version and rollback history.
+func (s *server) handleListSources(w http.ResponseWriter, r *http.Request) {
+	sources, err := s.ppa.listSources(r.Context())
+	if err != nil {
+		slog.Error("Listing sources failed", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, sources)
+}
+
+// handlePoll makes a registered source check upstream immediately.
+func (s *server) handlePoll(w http.ResponseWriter, r *http.Request) {
+	if err := s.ppa.triggerPoll(r.PathValue("name")); err != nil {
+		writeJSONError(w, http.StatusNotFound, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "poll triggered"})
+}
+
+// handleDeleteSource removes a source's packages, history and state.
+func (s *server) handleDeleteSource(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if err := s.ppa.DeleteSource(r.Context(), name); err != nil {
+		if errors.Is(err, errUnknownSource) {
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		}
+		slog.Error("Deleting source failed", "source", name, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "deleted"})
+}
+
+// handleRollback republishes an earlier version of a source. The optional
+// JSON body {"version": "..."} picks the version, otherwise the newest one
+// older than the current is used.
+func (s *server) handleRollback(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+
+	var req struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil && err != io.EOF {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	version, err := s.ppa.Rollback(r.Context(), name, req.Version)
+	if err != nil {
+		if errors.Is(err, errUnknownSource) || errors.Is(err, errUnknownVersion) {
+			writeJSONError(w, http.StatusNotFound, err)
+			return
+		}
+		slog.Error("Rollback failed", "source", name, "error", err)
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"source": name, "version": version})
+}
+
+// handleRegenerate rebuilds and re-signs the repo metadata.
+func (s *server) handleRegenerate(w http.ResponseWriter, r *http.Request) {
+	if err := s.ppa.Regenerate(r.Context()); err != nil {
+		slog.Error("Regenerating metadata failed", "error", err)
+		writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "regenerated"})
+}
+
+// maxWebhookSize bounds GitHub webhook payloads, which GitHub caps at 25 MB.
+const maxWebhookSize = 25 * 1024 * 1024
+
+// handleGitHubWebhook triggers a poll of the sources tracking a repository
+// when GitHub reports a published release. Deliveries are authenticated by
+// their X-Hub-Signature-256 HMAC instead of a bearer token.
+func (s *server) handleGitHubWebhook(w http.ResponseWriter, r *http.Request) {
+	if s.webhookSecret == "" {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookSize))
+	if err != nil {
+		writeJSONError(w, http.StatusRequestEntityTooLarge, err)
+		return
+	}
+	if !validWebhookSignature(body, r.Header.Get("X-Hub-Signature-256"), s.webhookSecret) {
+		slog.Warn("Rejected GitHub webhook with invalid signature", "delivery", r.Header.Get("X-GitHub-Delivery"))
+		writeJSONError(w, http.StatusUnauthorized, errors.New("invalid signature"))
+		return
+	}
+
+	switch event := r.Header.Get("X-GitHub-Event"); event {
+	case "ping":
+		writeJSON(w, http.StatusOK, map[string]string{"status": "pong"})
+		return
+	case "release":
+	default:
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored event " + event})
+		return
+	}
+
+	var payload struct {
+		Action     string `json:"action"`
+		Repository struct {
+			FullName string `json:"full_name"`
+		} `json:"repository"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+	if payload.Action != "published" && payload.Action != "released" {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ignored action " + payload.Action})
+		return
+	}
+
+	triggered := s.ppa.triggerGitHubPolls(payload.Repository.FullName)
+	slog.Info("GitHub release webhook received", "repo", payload.Repository.FullName, "action", payload.Action, "triggered", triggered)
+	writeJSON(w, http.StatusAccepted, map[string]any{"triggered": triggered})
+}
+
+// validWebhookSignature checks a GitHub "sha256=<hex>" HMAC of body.
+func validWebhookSignature(body []byte, signature, secret string) bool {
+	sig, ok := strings.CutPrefix(signature, "sha256=")
+	if !ok {
+		return false
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hmac.Equal(got, mac.Sum(nil))
+}
@@ -0,0 +1,206 @@
+package ppa
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// objectCache is a size-bounded LRU of proxied repository objects. Metadata
+// under dists/ expires after a TTL and is dropped whenever this process
+// regenerates it; the TTL covers regenerations by other processes such as
+// the publish command. Pool objects only change when deleted, migrated or
+// republished, so after the same TTL they are revalidated against storage
+// by ETag instead of being downloaded again.
+type objectCache struct {
+	maxBytes      int64
+	maxObjectSize int64
+	metadataTTL   time.Duration
+
+	mu    sync.Mutex
+	size  int64
+	lru   *list.List // of *cachedObject, most recently used first
+	items map[string]*list.Element
+}
+
+type cachedObject struct {
+	key          string
+	data         []byte
+	etag         string
+	contentType  string
+	lastModified time.Time
+	expires      time.Time // dists/ objects are dropped and others revalidated after
+	immutable    bool
+}
+
+// newObjectCache returns a cache holding up to maxBytes, or nil if
+// maxBytes is 0. A nil cache caches nothing.
+func newObjectCache(maxBytes int64, metadataTTL time.Duration) *objectCache {
+	if maxBytes <= 0 {
+		return nil
+	}
+	return &objectCache{
+		maxBytes:      maxBytes,
+		maxObjectSize: maxBytes / 4,
+		metadataTTL:   metadataTTL,
+		lru:           list.New(),
+		items:         map[string]*list.Element{},
+	}
+}
+
+// get returns a cached object, or nil. If revalidate is set, the object
+// must be checked against storage before it is served; see refresh.
+func (c *objectCache) get(key string) (obj *cachedObject, revalidate bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		cacheLookups.inc("miss")
+		return nil, false
+	}
+	obj = el.Value.(*cachedObject)
+	expired := time.Now().After(obj.expires)
+	if expired && !obj.immutable {
+		c.remove(el)
+		cacheLookups.inc("miss")
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	cacheLookups.inc("hit")
+	return obj, expired
+}
+
+// refresh marks a revalidated object as fresh for another TTL.
+func (c *objectCache) refresh(obj *cachedObject) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	obj.expires = time.Now().Add(c.metadataTTL)
+}
+
+// drop removes the object cached under key, if any.
+func (c *objectCache) drop(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+}
+
+// fits reports whether an object of the given size may be cached.
+func (c *objectCache) fits(size int64) bool {
+	return c != nil && size <= c.maxObjectSize
+}
+
+// put stores obj, evicting the least recently used objects to make room.
+func (c *objectCache) put(obj *cachedObject) {
+	if !c.fits(int64(len(obj.data))) {
+		return
+	}
+	obj.immutable = strings.HasPrefix(obj.key, "pool/") || strings.Contains(obj.key, "/by-hash/")
+	obj.expires = time.Now().Add(c.metadataTTL)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[obj.key]; ok {
+		c.remove(el)
+	}
+	c.items[obj.key] = c.lru.PushFront(obj)
+	c.size += int64(len(obj.data))
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+// invalidateMetadata drops every cached dists/ object.
+func (c *objectCache) invalidateMetadata() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, el := range c.items {
+		if strings.HasPrefix(key, "dists/") {
+			c.remove(el)
+		}
+	}
+}
+
+func (c *objectCache) remove(el *list.Element) {
+	obj := c.lru.Remove(el).(*cachedObject)
+	delete(c.items, obj.key)
+	c.size -= int64(len(obj.data))
+}
+
+// serveCached writes a cached object, answering conditional, Range and HEAD
+// requests like the proxy does.
+func (s *server) serveCached(w http.ResponseWriter, r *http.Request, obj *cachedObject) {
+	h := w.Header()
+	h.Set("Cache-Control", cacheControl(obj.key))
+	if obj.etag != "" {
+		h.Set("ETag", obj.etag)
+	}
+	if obj.contentType != "" {
+		h.Set("Content-Type", obj.contentType)
+	}
+	http.ServeContent(w, r, "", obj.lastModified, bytes.NewReader(obj.data))
+}
+
+// revalidate checks whether a cached object still matches storage. Changed
+// and deleted objects are dropped from the cache. While storage fails, the
+// cached copy is kept and served.
+func (s *server) revalidate(r *http.Request, obj *cachedObject) bool {
+	if obj.etag == "" {
+		s.cache.drop(obj.key)
+		return false
+	}
+	head, err := s.s3.HeadObject(r.Context(), obj.key, GetOptions{IfNoneMatch: obj.etag})
+	switch kind := classifyStorageError(err); {
+	case notModified(err) != nil, err == nil && head.ETag != nil && *head.ETag == obj.etag:
+		s.cache.refresh(obj)
+		return true
+	case err != nil && kind != storageNotFound:
+		return true
+	}
+	s.cache.drop(obj.key)
+	return false
+}
+
+// fillCache downloads a whole object into the cache, ignoring the client's
+// conditions so that conditional requests warm the cache too.
+func (s *server) fillCache(r *http.Request, key string) (*cachedObject, error) {
+	output, err := s.s3.GetObject(r.Context(), key, GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer output.Body.Close()
+
+	var buf bytes.Buffer
+	if _, err := buf.ReadFrom(output.Body); err != nil {
+		return nil, err
+	}
+
+	obj := &cachedObject{key: key, data: buf.Bytes()}
+	if output.ETag != nil {
+		obj.etag = *output.ETag
+	}
+	if output.ContentType != nil {
+		obj.contentType = *output.ContentType
+	}
+	if output.LastModified != nil {
+		obj.lastModified = *output.LastModified
+	}
+	s.cache.put(obj)
+	return obj, nil
+}
@@ -3,10 +3,14 @@ package ppa
 import (
 	"archive/tar"
 	"bufio"
+	"compress/bzip2"
 	"compress/gzip"
 	"fmt"
 	"io"
 	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
 )
 
 type DebControl struct {
@@ -26,6 +30,50 @@ type ControlField struct {
 	Value string
 }
 
+// Get returns the value of a control field, or "" if it is not set.
+func (c *DebControl) Get(key string) string {
+	for _, f := range c.Fields {
+		if strings.EqualFold(f.Key, key) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Set replaces the value of a control field, appending it if not present.
+func (c *DebControl) Set(key, value string) {
+	c.setKnown(key, value)
+	for i, f := range c.Fields {
+		if strings.EqualFold(f.Key, key) {
+			c.Fields[i].Value = value
+			return
+		}
+	}
+	c.Fields = append(c.Fields, ControlField{Key: key, Value: value})
+}
+
+// setKnown mirrors a field into the matching struct field, if any.
+func (c *DebControl) setKnown(key, value string) {
+	switch key {
+	case "Package":
+		c.Package = value
+	case "Version":
+		c.Version = value
+	case "Architecture":
+		c.Architecture = value
+	case "Maintainer":
+		c.Maintainer = value
+	case "Description":
+		c.Description = value
+	case "Depends":
+		c.Depends = value
+	case "Section":
+		c.Section = value
+	case "Priority":
+		c.Priority = value
+	}
+}
+
 func ParseDebControl(r io.Reader) (*DebControl, error) {
 	ar, err := newArReader(r)
 	if err != nil {
@@ -52,19 +100,9 @@ func ParseDebControl(r io.Reader) (*DebControl, error) {
 }
 
 func parseControlTar(r io.Reader, name string) (*DebControl, error) {
-	var tarReader *tar.Reader
-
-	if strings.HasSuffix(name, ".gz") {
-		gz, err := gzip.NewReader(r)
-		if err != nil {
-			return nil, fmt.Errorf("opening gzip: %w", err)
-		}
-		defer gz.Close()
-		tarReader = tar.NewReader(gz)
-	} else if strings.HasSuffix(name, ".xz") || strings.HasSuffix(name, ".zst") {
-		return nil, fmt.Errorf("%s compression not supported", name)
-	} else {
-		tarReader = tar.NewReader(r)
+	tarReader, err := openTar(r, name)
+	if err != nil {
+		return nil, err
 	}
 
 	for {
@@ -85,6 +123,38 @@ func parseControlTar(r io.Reader, name string) (*DebControl, error) {
 	return nil, fmt.Errorf("control file not found in control.tar")
 }
 
+// openTar opens a tar member of a .deb, decompressing it according to the
+// member name's extension.
+func openTar(r io.Reader, name string) (*tar.Reader, error) {
+	switch {
+	case strings.HasSuffix(name, ".gz"):
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("opening gzip: %w", err)
+		}
+		return tar.NewReader(gz), nil
+	case strings.HasSuffix(name, ".xz"):
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("opening xz: %w", err)
+		}
+		return tar.NewReader(xr), nil
+	case strings.HasSuffix(name, ".zst"):
+		// A single-threaded decoder starts no goroutines that would need closing.
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("opening zstd: %w", err)
+		}
+		return tar.NewReader(zr.IOReadCloser()), nil
+	case strings.HasSuffix(name, ".bz2"):
+		return tar.NewReader(bzip2.NewReader(r)), nil
+	case strings.HasSuffix(name, ".tar"):
+		return tar.NewReader(r), nil
+	default:
+		return nil, fmt.Errorf("%s: unsupported compression", name)
+	}
+}
+
 func parseControlFile(r io.Reader) (*DebControl, error) {
 	ctrl := &DebControl{}
 	scanner := bufio.NewScanner(r)
@@ -97,24 +167,7 @@ func parseControlFile(r io.Reader) (*DebControl, error) {
 		}
 		value := strings.TrimSpace(currentValue)
 		ctrl.Fields = append(ctrl.Fields, ControlField{Key: currentKey, Value: value})
-		switch currentKey {
-		case "Package":
-			ctrl.Package = value
-		case "Version":
-			ctrl.Version = value
-		case "Architecture":
-			ctrl.Architecture = value
-		case "Maintainer":
-			ctrl.Maintainer = value
-		case "Description":
-			ctrl.Description = value
-		case "Depends":
-			ctrl.Depends = value
-		case "Section":
-			ctrl.Section = value
-		case "Priority":
-			ctrl.Priority = value
-		}
+		ctrl.setKnown(currentKey, value)
 	}
 
 	for scanner.Scan() {
@@ -26,7 +26,7 @@ type DebEntry struct {
 
 // BuildDeb creates a .deb ar archive from control fields and data entries.
 func BuildDeb(ctrl DebControl, entries []DebEntry) ([]byte, error) {
-	controlTar, err := buildControlTar(ctrl)
+	controlTar, err := buildControlTar(ctrl, nil)
 	if err != nil {
 		return nil, fmt.Errorf("building control.tar.gz: %w", err)
 	}
@@ -36,6 +36,11 @@ func BuildDeb(ctrl DebControl, entries []DebEntry) ([]byte, error) {
 		return nil, fmt.Errorf("building data.tar.gz: %w", err)
 	}
 
+	return writeDeb(controlTar, "data.tar.gz", dataTar)
+}
+
+// writeDeb assembles the ar container of a .deb from its member archives.
+func writeDeb(controlTar []byte, dataName string, dataTar []byte) ([]byte, error) {
 	var buf bytes.Buffer
 	w, err := newArWriter(&buf)
 	if err != nil {
@@ -50,14 +55,16 @@ func BuildDeb(ctrl DebControl, entries []DebEntry) ([]byte, error) {
 	if err := w.writeEntry(arHeader{Name: "control.tar.gz", ModTime: now, Mode: 0100644}, controlTar); err != nil {
 		return nil, err
 	}
-	if err := w.writeEntry(arHeader{Name: "data.tar.gz", ModTime: now, Mode: 0100644}, dataTar); err != nil {
+	if err := w.writeEntry(arHeader{Name: dataName, ModTime: now, Mode: 0100644}, dataTar); err != nil {
 		return nil, err
 	}
 
 	return buf.Bytes(), nil
 }
 
-func buildControlTar(ctrl DebControl) ([]byte, error) {
+// buildControlTar writes the control file followed by extra control members
+// such as maintainer scripts, md5sums and conffiles.
+func buildControlTar(ctrl DebControl, extra []DebEntry) ([]byte, error) {
 	var controlContent bytes.Buffer
 	for _, f := range ctrl.Fields {
 		fmt.Fprintf(&controlContent, "%s: %s\n", f.Key, f.Value)
@@ -80,6 +87,20 @@ func buildControlTar(ctrl DebControl) ([]byte, error) {
 		return nil, err
 	}
 
+	for _, e := range extra {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:   "./" + e.Path,
+			Size:   int64(len(e.Body)),
+			Mode:   e.Mode,
+			Format: tar.FormatGNU,
+		}); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(e.Body); err != nil {
+			return nil, err
+		}
+	}
+
 	if err := tw.Close(); err != nil {
 		return nil, err
 	}
@@ -67,3 +67,16 @@ func (g *GPGSigner) DetachedSign(content []byte) ([]byte, error) {
 	}
 	return buf.Bytes(), nil
 }
+
+// VerifyClearSigned checks that a clearsigned message was signed by this
+// signer's key and returns its plaintext.
+func (g *GPGSigner) VerifyClearSigned(data []byte) ([]byte, error) {
+	block, _ := clearsign.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no clearsigned message found")
+	}
+	if _, err := block.VerifySignature(openpgp.EntityList{g.entity}, nil); err != nil {
+		return nil, fmt.Errorf("verifying signature: %w", err)
+	}
+	return block.Plaintext, nil
+}
@@ -0,0 +1,66 @@
+package ppa
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// handleHealthz reports that the process is alive.
+func (s *server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+// handleReadyz reports whether the repository can be served: storage is
+// reachable and the stable suite's InRelease exists, is signed by the
+// current key and, if a maximum age is configured, is recent enough.
+func (s *server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	ready := s.checkReady(r, checks)
+
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, map[string]any{"ready": ready, "checks": checks})
+}
+
+// checkReady runs the readiness checks in order, recording each outcome in
+// checks and stopping at the first failure.
+func (s *server) checkReady(r *http.Request, checks map[string]string) bool {
+	key := "dists/" + defaultSuite + "/InRelease"
+	inRelease, err := s.s3.Download(r.Context(), key)
+	if err != nil {
+		if !isNotFound(err) {
+			checks["storage"] = err.Error()
+			return false
+		}
+		checks["storage"] = "ok"
+		checks["inrelease"] = key + " not found"
+		return false
+	}
+	checks["storage"] = "ok"
+	checks["inrelease"] = "ok"
+
+	release, err := s.signer.VerifyClearSigned(inRelease)
+	if err != nil {
+		checks["signature"] = err.Error()
+		return false
+	}
+	checks["signature"] = "ok"
+
+	if s.readyMaxAge > 0 {
+		date, err := time.Parse(time.RFC1123, StanzaField(string(release), "Date"))
+		if err != nil {
+			checks["age"] = fmt.Sprintf("parsing Date: %v", err)
+			return false
+		}
+		if age := time.Since(date); age > s.readyMaxAge {
+			checks["age"] = fmt.Sprintf("InRelease is %s old, maximum is %s", age.Round(time.Second), s.readyMaxAge)
+			return false
+		}
+		checks["age"] = "ok"
+	}
+	return true
+}
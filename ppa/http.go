@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"mime"
 	"net/http"
+	"path"
 	"strconv"
 	"time"
 )
@@ -43,3 +45,18 @@ func HTTPWithRetry(ctx context.Context, url, method string) (*http.Response, err
 	}
 	return nil, fmt.Errorf("rate limited after 3 retries")
 }
+
+// ResponseFilename returns the name of the file served by resp: the
+// Content-Disposition filename if present, otherwise the last path segment
+// of the final URL after redirects.
+func ResponseFilename(resp *http.Response) string {
+	if cd := resp.Header.Get("Content-Disposition"); cd != "" {
+		if _, params, err := mime.ParseMediaType(cd); err == nil && params["filename"] != "" {
+			return params["filename"]
+		}
+	}
+	if resp.Request == nil || resp.Request.URL == nil {
+		return ""
+	}
+	return path.Base(resp.Request.URL.Path)
+}
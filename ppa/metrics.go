@@ -0,0 +1,262 @@
+package ppa
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics are exposed at /metrics in the Prometheus text format. The
+// collectors below are a minimal stand-in for the Prometheus client library.
+var (
+	pollAttempts        = newMetricVec("ppa_poll_attempts_total", "counter", "Upstream polls per source.", "source")
+	pollFailures        = newMetricVec("ppa_poll_failures_total", "counter", "Failed upstream polls per source.", "source")
+	fetchBytes          = newMetricVec("ppa_fetch_bytes_total", "counter", "Bytes of .deb packages fetched from upstream.", "source")
+	unverifiedPublishes = newMetricVec("ppa_unverified_publishes_total", "counter", "Packages published although upstream offered nothing to verify them against.", "source")
+	fetchSeconds        = newHistogramVec("ppa_fetch_duration_seconds", "Duration of upstream package fetches.",
+		[]float64{0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}, "source")
+	regenerateSeconds = newHistogramVec("ppa_metadata_regeneration_duration_seconds", "Duration of repo metadata regeneration.",
+		[]float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30})
+	httpRequests      = newMetricVec("ppa_http_requests_total", "counter", "HTTP requests by path class and status code.", "path", "code")
+	httpBytes         = newMetricVec("ppa_http_response_bytes_total", "counter", "Response bytes served by path class.", "path")
+	storageErrors     = newMetricVec("ppa_storage_errors_total", "counter", "Failed storage operations by kind of error.", "operation", "kind")
+	cacheLookups      = newMetricVec("ppa_cache_lookups_total", "counter", "Object cache lookups by result.", "result")
+	rateLimited       = newMetricVec("ppa_rate_limited_requests_total", "counter", "Requests rejected by the per-client rate limit.", "budget")
+	lastPublishedTime = newMetricVec("ppa_last_publish_timestamp_seconds", "gauge", "Unix time a package was last published.", "suite", "package", "architecture")
+)
+
+var allMetrics = []metric{
+	pollAttempts, pollFailures, fetchBytes, unverifiedPublishes, fetchSeconds, regenerateSeconds,
+	httpRequests, httpBytes, storageErrors, cacheLookups, rateLimited, lastPublishedTime,
+}
+
+type metric interface {
+	write(w io.Writer)
+}
+
+// labelKey joins label values into a map key.
+func labelKey(values []string) string {
+	return strings.Join(values, "\xff")
+}
+
+// labelEscaper escapes label values as the Prometheus text format requires.
+// Everything else, including non-ASCII characters, is written as is.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// formatLabels renders {name="value",...} for the given label names and key.
+func formatLabels(names []string, key string, extra ...string) string {
+	var pairs []string
+	if len(names) > 0 {
+		for i, value := range strings.Split(key, "\xff") {
+			pairs = append(pairs, names[i]+`="`+labelEscaper.Replace(value)+`"`)
+		}
+	}
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, extra[i]+`="`+labelEscaper.Replace(extra[i+1])+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// metricVec is a counter or gauge partitioned by labels.
+type metricVec struct {
+	name, kind, help string
+	labels           []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+func newMetricVec(name, kind, help string, labels ...string) *metricVec {
+	return &metricVec{name: name, kind: kind, help: help, labels: labels, values: map[string]float64{}}
+}
+
+func (m *metricVec) add(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[labelKey(labelValues)] += v
+}
+
+func (m *metricVec) inc(labelValues ...string) {
+	m.add(1, labelValues...)
+}
+
+func (m *metricVec) set(v float64, labelValues ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.values[labelKey(labelValues)] = v
+}
+
+func (m *metricVec) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+	for _, key := range slices.Sorted(maps.Keys(m.values)) {
+		fmt.Fprintf(w, "%s%s %s\n", m.name, formatLabels(m.labels, key), formatFloat(m.values[key]))
+	}
+}
+
+// histogramVec is a histogram partitioned by labels.
+type histogramVec struct {
+	name, help string
+	labels     []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	count  uint64
+	sum    float64
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *histogramVec {
+	return &histogramVec{name: name, help: help, labels: labels, buckets: buckets, series: map[string]*histogram{}}
+}
+
+func (h *histogramVec) observe(v float64, labelValues ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := labelKey(labelValues)
+	s := h.series[key]
+	if s == nil {
+		s = &histogram{counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	if i, _ := slices.BinarySearch(h.buckets, v); i < len(h.buckets) {
+		s.counts[i]++
+	}
+	s.count++
+	s.sum += v
+}
+
+// observeSince records the seconds elapsed since start.
+func (h *histogramVec) observeSince(start time.Time, labelValues ...string) {
+	h.observe(time.Since(start).Seconds(), labelValues...)
+}
+
+func (h *histogramVec) write(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	for _, key := range slices.Sorted(maps.Keys(h.series)) {
+		s := h.series[key]
+		var cumulative uint64
+		for i, le := range h.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", formatFloat(le)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, key, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, key), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, key), s.count)
+	}
+}
+
+// loadPublishTimes initializes the last publish time of every package from
+// the modification time of its source's packages entry, so staleness alerts
+// survive restarts.
+func (p *PPA) loadPublishTimes(ctx context.Context) {
+	keys, err := p.s3.ListPrefix(ctx, "meta/")
+	if err != nil {
+		slog.Warn("Failed to load publish times", "error", err)
+		return
+	}
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/packages-entry") {
+			continue
+		}
+		modified, err := p.s3.LastModified(ctx, key)
+		if err != nil {
+			continue
+		}
+		data, err := p.s3.Download(ctx, key)
+		if err != nil {
+			continue
+		}
+		suite := defaultSuite
+		if s, err := p.s3.Download(ctx, strings.TrimSuffix(key, "packages-entry")+"suite"); err == nil && len(s) > 0 {
+			suite = string(s)
+		}
+		for _, stanza := range SplitStanzas(string(data)) {
+			lastPublishedTime.set(float64(modified.Unix()), suite, StanzaField(stanza, "Package"), StanzaField(stanza, "Architecture"))
+		}
+	}
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range allMetrics {
+		m.write(w)
+	}
+}
+
+// pathClass groups request paths into a few values for metric labels.
+func pathClass(path string) string {
+	first, _, _ := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	switch first {
+	case "":
+		return "/"
+	case "pool", "dists", "key.gpg", "api", "status", "metrics", "healthz", "readyz":
+		return "/" + first
+	}
+	return "other"
+}
+
+// instrument counts requests and response bytes per path class and status.
+func instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		class := pathClass(r.URL.Path)
+		httpRequests.inc(class, strconv.Itoa(rec.status))
+		httpBytes.add(float64(rec.bytes), class)
+	})
+}
+
+// responseRecorder captures the status code and body size of a response.
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if !r.wroteHeader {
+		r.status = status
+		r.wroteHeader = true
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
@@ -0,0 +1,138 @@
+package ppa
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestMetricVecWrite(t *testing.T) {
+	m := newMetricVec("test_requests_total", "counter", "Test requests.", "path", "code")
+	m.inc("/pool", "200")
+	m.add(2, "/pool", "200")
+	m.inc(`C:\dir "quoted"`+"\nnext", "500")
+
+	var out strings.Builder
+	m.write(&out)
+	want := `# HELP test_requests_total Test requests.
+# TYPE test_requests_total counter
+test_requests_total{path="/pool",code="200"} 3
+test_requests_total{path="C:\\dir \"quoted\"\nnext",code="500"} 1
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestMetricVecWithoutLabels(t *testing.T) {
+	m := newMetricVec("test_timestamp_seconds", "gauge", "Test gauge.")
+	m.set(1.5)
+
+	var out strings.Builder
+	m.write(&out)
+	want := `# HELP test_timestamp_seconds Test gauge.
+# TYPE test_timestamp_seconds gauge
+test_timestamp_seconds 1.5
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+func TestHistogramVecWrite(t *testing.T) {
+	h := newHistogramVec("test_duration_seconds", "Test durations.", []float64{0.5, 1, 2.5}, "source")
+	for _, v := range []float64{0.1, 0.5, 0.7, 3} {
+		h.observe(v, "discord")
+	}
+
+	var out strings.Builder
+	h.write(&out)
+	want := `# HELP test_duration_seconds Test durations.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{source="discord",le="0.5"} 2
+test_duration_seconds_bucket{source="discord",le="1"} 3
+test_duration_seconds_bucket{source="discord",le="2.5"} 3
+test_duration_seconds_bucket{source="discord",le="+Inf"} 4
+test_duration_seconds_sum{source="discord"} 4.3
+test_duration_seconds_count{source="discord"} 4
+`
+	if out.String() != want {
+		t.Errorf("got:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
+
+// sampleLine matches a sample in the text exposition format: a metric name,
+// optional labels with escaped values, and a value.
+var sampleLine = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*"(?:,[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*")*\})? (\S+)$`)
+
+func TestHandleMetrics(t *testing.T) {
+	pollAttempts.inc(`source "with" quotes`)
+	fetchSeconds.observe(1, "discord")
+
+	rec := httptest.NewRecorder()
+	(&server{}).handleMetrics(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	types := map[string]string{}
+	helped := map[string]bool{}
+	for line := range strings.Lines(rec.Body.String()) {
+		line = strings.TrimSuffix(line, "\n")
+		if name, ok := strings.CutPrefix(line, "# HELP "); ok {
+			name, _, _ = strings.Cut(name, " ")
+			helped[name] = true
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, kind, _ := strings.Cut(rest, " ")
+			if !helped[name] {
+				t.Errorf("TYPE of %s without HELP", name)
+			}
+			if _, dup := types[name]; dup {
+				t.Errorf("%s declared twice", name)
+			}
+			types[name] = kind
+			continue
+		}
+
+		m := sampleLine.FindStringSubmatch(line)
+		if m == nil {
+			t.Errorf("malformed sample line %q", line)
+			continue
+		}
+		name := m[1]
+		family := name
+		for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+			if base, ok := strings.CutSuffix(name, suffix); ok && types[base] == "histogram" {
+				family = base
+			}
+		}
+		switch kind := types[family]; {
+		case kind == "":
+			t.Errorf("sample %s before its TYPE line", name)
+		case kind == "histogram" && family == name:
+			t.Errorf("histogram sample %s without _bucket, _sum or _count suffix", name)
+		case kind == "histogram" && strings.HasSuffix(name, "_bucket") && !strings.Contains(m[2], `le="`):
+			t.Errorf("bucket without le label: %q", line)
+		}
+	}
+
+	for _, m := range allMetrics {
+		var name string
+		switch m := m.(type) {
+		case *metricVec:
+			name = m.name
+		case *histogramVec:
+			name = m.name
+		}
+		if types[name] == "" {
+			t.Errorf("%s missing from /metrics", name)
+		}
+	}
+	if !strings.Contains(rec.Body.String(), `ppa_poll_attempts_total{source="source \"with\" quotes"} 1`) {
+		t.Error("escaped label value missing")
+	}
+}
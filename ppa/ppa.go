@@ -6,11 +6,14 @@ import (
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
+	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"net/http"
+	"net/netip"
 	"regexp"
-	"sort"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -18,8 +21,23 @@ import (
 
 const maxDebSize = 512 * 1024 * 1024 // 512 MB
 
+// defaultSuite is the suite sources publish to unless registered otherwise.
+const defaultSuite = "stable"
+
+// defaultComponent is the component polled sources publish to.
+const defaultComponent = "main"
+
 var safeDebField = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9.+~:\-]*$`)
 
+// archiveName matches suite and component names, which become path
+// segments under dists/ and pool/.
+var archiveName = regexp.MustCompile(`^[a-z0-9][a-z0-9.+-]*$`)
+
+// ValidArchiveName reports whether name may be used as a suite or component.
+func ValidArchiveName(name string) bool {
+	return archiveName.MatchString(name)
+}
+
 type Config struct {
 	S3Endpoint  string
 	S3Bucket    string
@@ -34,11 +52,48 @@ type Config struct {
 	Origin     string // e.g. "ppa.matejpavlicek.cz"
 	Label      string // e.g. "PPA"
 	Maintainer string // e.g. "PPA <ppa@example.com>"
+
+	AllowDowngrade bool // publish versions older than the current one
+
+	APITokens []string // bearer tokens accepted by /api/ endpoints; the API is disabled if empty
+
+	GitHubWebhookSecret string // verifies GitHub release webhooks; the webhook is disabled if empty
+
+	ReadyMaxAge time.Duration // /readyz fails if the stable InRelease is older; disabled if 0
+
+	// PoolRedirect makes pool downloads bypass the server: "presign"
+	// redirects to presigned storage URLs valid for PoolPresignTTL, "cdn" to
+	// the same path under PoolCDNBaseURL. Empty proxies them.
+	PoolRedirect   string
+	PoolPresignTTL time.Duration
+	PoolCDNBaseURL string
+
+	CacheSize        int64         // bytes of proxied objects kept in memory; disabled if 0
+	CacheMetadataTTL time.Duration // how long cached dists/ objects are served
+
+	AccessLog         bool           // log every request as JSON to stdout
+	AccessLogParseAPT bool           // add apt version and distribution parsed from the User-Agent
+	TrustedProxies    []netip.Prefix // proxies whose X-Forwarded-For is believed
+
+	RateLimitMetadata RateLimit // per-client budget for dists/ and key.gpg
+	RateLimitPool     RateLimit // per-client budget for pool/
+
+	StatsSalt string // keys the client hashes of download statistics; random per process if empty
 }
 
 type SourceRegistration struct {
 	Source       Source
 	PollInterval time.Duration
+	Suite        string // APT suite the package is published to, "stable" if empty
+	Transforms   []Transform
+	Verification Verification // checks of the fetched artifact; publishes are reported unverified without
+}
+
+func (r SourceRegistration) suite() string {
+	if r.Suite == "" {
+		return defaultSuite
+	}
+	return r.Suite
 }
 
 type PPA struct {
@@ -47,7 +102,16 @@ type PPA struct {
 	signer *GPGSigner
 	mu     sync.Mutex // serializes repo metadata regeneration
 
-	sources []SourceRegistration
+	sources  []SourceRegistration
+	triggers map[string]chan struct{} // source name -> immediate poll request
+
+	latestMu sync.RWMutex
+	latest   map[string]publishedPackage // source name -> newest published package
+
+	statusMu sync.RWMutex
+	status   map[string]sourceStatus // source name -> outcome of recent polls
+
+	onRegenerate []func(dists map[string][]byte) // called with the dists/ files after repo metadata was regenerated
 }
 
 func New(cfg Config) (*PPA, error) {
@@ -65,40 +129,80 @@ func New(cfg Config) (*PPA, error) {
 	})
 
 	return &PPA{
-		cfg:    cfg,
-		s3:     s3Client,
-		signer: signer,
+		cfg:      cfg,
+		s3:       s3Client,
+		signer:   signer,
+		triggers: map[string]chan struct{}{},
+		status:   map[string]sourceStatus{},
 	}, nil
 }
 
 func (p *PPA) Register(reg SourceRegistration) {
 	p.sources = append(p.sources, reg)
+	p.triggers[reg.Source.Name()] = make(chan struct{}, 1)
 }
 
-// DeleteSource removes all pool files, metadata, and state for a source,
-// then regenerates repo metadata.
+// DeleteSource removes all pool files, metadata, history and state for a
+// source, then regenerates repo metadata.
 func (p *PPA) DeleteSource(ctx context.Context, sourceName string) error {
 	slog.Info("Deleting source", "source", sourceName)
 
-	// Find and delete all pool files referenced by this source's packages-entry
-	entryData, err := p.s3.Download(ctx, "meta/"+sourceName+"/packages-entry")
-	if err == nil {
+	keys, err := p.s3.ListPrefix(ctx, "meta/"+sourceName+"/")
+	if err != nil {
+		return fmt.Errorf("listing meta entries: %w", err)
+	}
+	// The prefix also matches sources nested below this name, such as
+	// every upload for "uploads"; only this source's own keys are deleted.
+	// Any of them, even just a status, makes the source known.
+	var metaKeys []string
+	for _, key := range keys {
+		if metaSourceName(key) == sourceName {
+			metaKeys = append(metaKeys, key)
+		}
+	}
+	if len(metaKeys) == 0 {
+		return fmt.Errorf("%s: %w", sourceName, errUnknownSource)
+	}
+
+	// Find and delete all pool files referenced by the current packages entry
+	// and the history
+	var filenames []string
+	for _, key := range metaKeys {
+		if !strings.HasSuffix(key, "/packages-entry") && !strings.Contains(key, "/history/") {
+			continue
+		}
+		entryData, err := p.s3.Download(ctx, key)
+		if err != nil {
+			continue
+		}
 		for _, line := range strings.Split(string(entryData), "\n") {
-			if strings.HasPrefix(line, "Filename: ") {
-				filename := strings.TrimPrefix(line, "Filename: ")
-				slog.Info("Deleting file", "source", sourceName, "file", filename)
-				if err := p.s3.Delete(ctx, filename); err != nil {
-					slog.Warn("Failed to delete file", "source", sourceName, "file", filename, "error", err)
-				}
+			if filename, ok := strings.CutPrefix(line, "Filename: "); ok && !slices.Contains(filenames, filename) {
+				filenames = append(filenames, filename)
 			}
 		}
 	}
+	// Pool paths don't include the suite, so the same file may also be
+	// published by another source, such as an upload to another suite.
+	shared, err := p.referencedPoolFiles(ctx, sourceName)
+	if err != nil {
+		return err
+	}
+	for _, filename := range filenames {
+		if shared[filename] {
+			slog.Info("Keeping file used by another source", "source", sourceName, "file", filename)
+			continue
+		}
+		slog.Info("Deleting file", "source", sourceName, "file", filename)
+		if err := p.s3.Delete(ctx, filename); err != nil {
+			slog.Warn("Failed to delete file", "source", sourceName, "file", filename, "error", err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
 
 	// Delete meta files
-	for _, key := range []string{
-		"meta/" + sourceName + "/packages-entry",
-		"meta/" + sourceName + "/state",
-	} {
+	for _, key := range metaKeys {
 		slog.Info("Deleting meta", "source", sourceName, "key", key)
 		if err := p.s3.Delete(ctx, key); err != nil {
 			slog.Warn("Failed to delete meta", "source", sourceName, "key", key, "error", err)
@@ -106,8 +210,6 @@ func (p *PPA) DeleteSource(ctx context.Context, sourceName string) error {
 	}
 
 	// Regenerate repo metadata without this source
-	p.mu.Lock()
-	defer p.mu.Unlock()
 
 	if err := p.regenerateRepoMetadata(ctx); err != nil {
 		return fmt.Errorf("regenerating repo metadata: %w", err)
@@ -117,20 +219,154 @@ func (p *PPA) DeleteSource(ctx context.Context, sourceName string) error {
 	return nil
 }
 
+// referencedPoolFiles returns the pool files listed in the packages entries
+// and history of every source except the excluded one.
+func (p *PPA) referencedPoolFiles(ctx context.Context, exclude string) (map[string]bool, error) {
+	keys, err := p.s3.ListPrefix(ctx, "meta/")
+	if err != nil {
+		return nil, fmt.Errorf("listing meta entries: %w", err)
+	}
+	files := map[string]bool{}
+	for _, key := range keys {
+		if metaSourceName(key) == exclude || (!strings.HasSuffix(key, "/packages-entry") && !strings.Contains(key, "/history/")) {
+			continue
+		}
+		data, err := p.s3.Download(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", key, err)
+		}
+		for _, stanza := range SplitStanzas(string(data)) {
+			if filename := StanzaField(stanza, "Filename"); filename != "" {
+				files[filename] = true
+			}
+		}
+	}
+	return files, nil
+}
+
+// MigratePool moves published packages to the pool layout produced by
+// PoolPath, rewriting each source's packages entry and history and
+// regenerating repo metadata. Old objects are kept for clients holding stale
+// indexes unless deleteOld is set. Entries are rewritten under p.mu, which
+// only guards against publishes in this process, so a server using the same
+// bucket must be stopped during the migration.
+func (p *PPA) MigratePool(ctx context.Context, deleteOld bool) error {
+	keys, err := p.s3.ListPrefix(ctx, "meta/")
+	if err != nil {
+		return fmt.Errorf("listing meta entries: %w", err)
+	}
+
+	var moved []string
+	for _, key := range keys {
+		if !strings.HasSuffix(key, "/packages-entry") && !strings.Contains(key, "/history/") {
+			continue
+		}
+		if moved, err = p.migrateEntry(ctx, key, moved); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	err = p.regenerateRepoMetadata(ctx)
+	p.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("regenerating repo metadata: %w", err)
+	}
+
+	if deleteOld {
+		for _, name := range moved {
+			slog.Info("Deleting old pool file", "file", name)
+			if err := p.s3.Delete(ctx, name); err != nil {
+				slog.Warn("Failed to delete old pool file", "file", name, "error", err)
+			}
+		}
+	}
+
+	slog.Info("Pool migration complete", "moved", len(moved))
+	return nil
+}
+
+// migrateEntry copies the packages listed in one packages entry or history
+// entry to their new pool paths and rewrites the entry. moved lists the old
+// pool files copied so far and is returned extended.
+func (p *PPA) migrateEntry(ctx context.Context, key string, moved []string) ([]string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	data, err := p.s3.Download(ctx, key)
+	if err != nil {
+		return moved, fmt.Errorf("downloading %s: %w", key, err)
+	}
+
+	component := defaultComponent
+	if c, err := p.s3.Download(ctx, "meta/"+metaSourceName(key)+"/component"); err == nil && len(c) > 0 {
+		component = string(c)
+	}
+
+	entry := string(data)
+	for _, stanza := range SplitStanzas(entry) {
+		ctrl, err := parseControlFile(strings.NewReader(stanza))
+		if err != nil {
+			return moved, fmt.Errorf("parsing %s: %w", key, err)
+		}
+		oldName := ctrl.Get("Filename")
+		newName := PoolPath(component, ctrl)
+		if oldName == newName {
+			continue
+		}
+		if slices.Contains(moved, oldName) {
+			// Already copied for the packages entry or another history entry.
+			entry = strings.Replace(entry, "Filename: "+oldName+"\n", "Filename: "+newName+"\n", 1)
+			continue
+		}
+
+		slog.Info("Moving package", "key", key, "from", oldName, "to", newName)
+		deb, err := p.s3.Download(ctx, oldName)
+		if err != nil {
+			return moved, fmt.Errorf("downloading %s: %w", oldName, err)
+		}
+		if err := p.s3.Upload(ctx, newName, deb, "application/vnd.debian.binary-package"); err != nil {
+			return moved, fmt.Errorf("uploading %s: %w", newName, err)
+		}
+		entry = strings.Replace(entry, "Filename: "+oldName+"\n", "Filename: "+newName+"\n", 1)
+		moved = append(moved, oldName)
+	}
+
+	if err := p.s3.Upload(ctx, key, []byte(entry), "text/plain"); err != nil {
+		return moved, fmt.Errorf("uploading %s: %w", key, err)
+	}
+	return moved, nil
+}
+
 func (p *PPA) Run(ctx context.Context) error {
 	var sources []sourceInfo
 	for _, reg := range p.sources {
 		sources = append(sources, sourceInfo{
 			Name:        reg.Source.Name(),
 			Description: reg.Source.Description(),
+			Suite:       reg.suite(),
 		})
 	}
 
-	srv := newServer(p.s3, p.signer, sources, p.cfg.Maintainer)
+	if _, latest, err := p.packagesEntries(ctx); err != nil {
+		slog.Warn("Failed to load published versions", "error", err)
+	} else {
+		p.setLatestVersions(latest)
+	}
+	p.loadStatuses(ctx)
+	p.loadPublishTimes(ctx)
+
+	srv := newServer(p, sources)
+	if (srv.metadataLimiter != nil || srv.poolLimiter != nil) && onlyLoopback(p.cfg.TrustedProxies) {
+		slog.Warn("Rate limiting with only loopback proxies trusted; behind a load balancer all clients share one budget", "trusted_proxies", p.cfg.TrustedProxies)
+	}
+	if err := srv.snapshot.load(ctx, p.s3); err != nil {
+		slog.Warn("Failed to load metadata snapshot", "error", err)
+	}
 	server := &http.Server{
 		Addr:         p.cfg.ListenAddr,
 		Handler:      srv.handler(),
-		ReadTimeout:  10 * time.Second,
+		ReadTimeout:  10 * time.Second, // extended per request for package uploads
 		WriteTimeout: 5 * time.Minute,
 	}
 
@@ -144,6 +380,12 @@ func (p *PPA) Run(ctx context.Context) error {
 		}()
 	}
 
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		srv.runStats(ctx)
+	}()
+
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
@@ -161,6 +403,11 @@ func (p *PPA) Run(ctx context.Context) error {
 	}
 
 	wg.Wait()
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer flushCancel()
+	if err := srv.stats.flush(flushCtx, p.s3); err != nil {
+		slog.Warn("Failed to store download statistics", "error", err)
+	}
 	slog.Info("Shutdown complete")
 	return nil
 }
@@ -169,7 +416,8 @@ func (p *PPA) runPoller(ctx context.Context, reg SourceRegistration) {
 	name := reg.Source.Name()
 	slog.Info("Starting poller", "source", name, "interval", reg.PollInterval)
 
-	p.poll(ctx, reg)
+	nextPoll := time.Now().Add(reg.PollInterval)
+	p.poll(ctx, reg, nextPoll)
 
 	ticker := time.NewTicker(reg.PollInterval)
 	defer ticker.Stop()
@@ -179,64 +427,314 @@ func (p *PPA) runPoller(ctx context.Context, reg SourceRegistration) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
-			p.poll(ctx, reg)
+			nextPoll = time.Now().Add(reg.PollInterval)
+			p.poll(ctx, reg, nextPoll)
+		case <-p.triggers[name]:
+			// Triggered polls don't reset the ticker.
+			slog.Info("Poll triggered", "source", name)
+			p.poll(ctx, reg, nextPoll)
 		}
 	}
 }
 
-func (p *PPA) poll(ctx context.Context, reg SourceRegistration) {
+// poll checks a source once and records the outcome in its status.
+func (p *PPA) poll(ctx context.Context, reg SourceRegistration, nextPoll time.Time) {
+	name := reg.Source.Name()
+	pollAttempts.inc(name)
+	err := p.checkSource(ctx, reg)
+	if err != nil {
+		pollFailures.inc(name)
+	}
+	p.recordPoll(ctx, name, nextPoll, err)
+}
+
+func (p *PPA) checkSource(ctx context.Context, reg SourceRegistration) error {
 	name := reg.Source.Name()
 	slog.Debug("Polling for new version", "source", name)
 
 	state, err := reg.Source.Check(ctx)
 	if err != nil {
 		slog.Error("Check failed", "source", name, "error", err)
-		return
+		return fmt.Errorf("check failed: %w", err)
 	}
 
 	lastState, err := p.s3.Download(ctx, "meta/"+name+"/state")
 	if err == nil && string(lastState) == state && state != "" {
 		slog.Debug("No new version detected", "source", name)
-		return
+		return nil
 	}
 
 	slog.Info("New version detected, fetching", "source", name)
 
-	debData, err := reg.Source.Fetch(ctx)
+	fetchCtx, report := withUnverifiedReport(ctx)
+	fetchStart := time.Now()
+	debData, err := reg.Source.Fetch(fetchCtx)
+	fetchSeconds.observeSince(fetchStart, name)
 	if err != nil {
+		var verr *VerificationError
+		if errors.As(err, &verr) {
+			slog.Error("Upstream artifact failed verification, refusing to publish", "source", name, "error", err)
+			return err
+		}
 		slog.Error("Fetch failed", "source", name, "error", err)
-		return
+		return fmt.Errorf("fetch failed: %w", err)
 	}
+	fetchBytes.add(float64(len(debData)), name)
 
-	if err := p.processNewDeb(ctx, name, state, debData); err != nil {
+	if reg.Verification.enabled() {
+		if err := reg.Verification.verify(ctx, debData); err != nil {
+			var verr *VerificationError
+			if errors.As(err, &verr) {
+				slog.Error("Upstream artifact failed verification, refusing to publish", "source", name, "error", err)
+				return err
+			}
+			slog.Error("Verification failed", "source", name, "error", err)
+			return fmt.Errorf("verification failed: %w", err)
+		}
+		report.reason = ""
+	}
+
+	if builder, ok := reg.Source.(Builder); ok {
+		debData, err = builder.Build(debData)
+		if err != nil {
+			slog.Error("Build failed", "source", name, "error", err)
+			return fmt.Errorf("build failed: %w", err)
+		}
+	}
+
+	if err := p.processNewDeb(ctx, reg, state, debData); err != nil {
 		slog.Error("Error processing new version", "source", name, "error", err)
+		return fmt.Errorf("processing new version: %w", err)
+	}
+	if report.reason != "" {
+		unverifiedPublishes.inc(name)
 	}
+	p.recordVerification(name, report.reason)
+	return nil
 }
 
-func (p *PPA) processNewDeb(ctx context.Context, sourceName, state string, debData []byte) error {
+func (p *PPA) processNewDeb(ctx context.Context, reg SourceRegistration, state string, debData []byte) error {
+	sourceName := reg.Source.Name()
+
 	if len(debData) > maxDebSize {
 		return fmt.Errorf(".deb exceeds maximum size (%d bytes)", maxDebSize)
 	}
 
+	if len(reg.Transforms) > 0 {
+		var err error
+		debData, err = applyTransforms(debData, reg.Transforms)
+		if err != nil {
+			return fmt.Errorf("transforming .deb: %w", err)
+		}
+		if len(debData) > maxDebSize {
+			return fmt.Errorf("transformed .deb exceeds maximum size (%d bytes)", maxDebSize)
+		}
+	}
+
+	ctrl, err := p.publish(ctx, publishTarget{sourceName: sourceName, suite: reg.suite(), component: defaultComponent}, debData)
+	if errors.Is(err, errPoolConflict) || errors.Is(err, errDowngrade) {
+		// Upstream rebuilt the same version or went back to an older one.
+		// Keep the published package and stop refetching this upstream
+		// state, but fail this poll so it shows up in the status and
+		// metrics.
+		if err := p.storeState(ctx, sourceName, state); err != nil {
+			return err
+		}
+		if errors.Is(err, errDowngrade) {
+			return err
+		}
+		return fmt.Errorf("upstream rebuilt the published version with different content: %w", err)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := p.storeState(ctx, sourceName, state); err != nil {
+		return err
+	}
+
+	slog.Info("Successfully processed", "source", sourceName, "package", ctrl.Package, "version", ctrl.Version)
+	return nil
+}
+
+// PublishDeb publishes a .deb built outside of any registered source, such
+// as an internal tool, running the same validation as polled packages. The
+// package is tracked under the pseudo-source "uploads/<suite>/<component>/<package>_<arch>".
+// Packages a polled source publishes to the same suite are refused unless
+// force is set, since both would end up in the index.
+func (p *PPA) PublishDeb(ctx context.Context, debData []byte, suite, component string, force bool) (*DebControl, error) {
+	if len(debData) > maxDebSize {
+		return nil, &InvalidPackageError{Err: fmt.Errorf(".deb exceeds maximum size (%d bytes)", maxDebSize)}
+	}
+	if suite == "" {
+		suite = defaultSuite
+	}
+	if component == "" {
+		component = defaultComponent
+	}
+	if !ValidArchiveName(suite) || !ValidArchiveName(component) {
+		return nil, &InvalidPackageError{Err: fmt.Errorf("invalid suite %q or component %q", suite, component)}
+	}
+
+	ctrl, err := ParseDebControl(bytes.NewReader(debData))
+	if err != nil {
+		return nil, &InvalidPackageError{Err: fmt.Errorf("parsing .deb: %w", err)}
+	}
+	if !safeDebField.MatchString(ctrl.Package) || !safeDebField.MatchString(ctrl.Architecture) {
+		return nil, &InvalidPackageError{Err: fmt.Errorf("invalid package name %q or architecture %q", ctrl.Package, ctrl.Architecture)}
+	}
+
+	if !force {
+		owner, err := p.polledSourceOf(ctx, suite, ctrl.Package)
+		if err != nil {
+			return nil, err
+		}
+		if owner != "" {
+			return nil, fmt.Errorf("%s in suite %s is published by source %s: %w", ctrl.Package, suite, owner, errPackageOwned)
+		}
+	}
+
+	sourceName := fmt.Sprintf("uploads/%s/%s/%s_%s", suite, component, ctrl.Package, ctrl.Architecture)
+	ctrl, err = p.publish(ctx, publishTarget{sourceName: sourceName, suite: suite, component: component}, debData)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Successfully published upload", "source", sourceName, "package", ctrl.Package, "version", ctrl.Version)
+	return ctrl, nil
+}
+
+// polledSourceOf returns the polled source publishing pkg to suite, or "" if
+// there is none.
+func (p *PPA) polledSourceOf(ctx context.Context, suite, pkg string) (string, error) {
+	keys, err := p.s3.ListPrefix(ctx, "meta/")
+	if err != nil {
+		return "", fmt.Errorf("listing meta entries: %w", err)
+	}
+	for _, key := range keys {
+		sourceName := metaSourceName(key)
+		if !strings.HasSuffix(key, "/packages-entry") || strings.HasPrefix(sourceName, "uploads/") {
+			continue
+		}
+		entry, err := p.s3.Download(ctx, key)
+		if err != nil {
+			return "", fmt.Errorf("reading %s: %w", key, err)
+		}
+		if !slices.ContainsFunc(SplitStanzas(string(entry)), func(stanza string) bool {
+			return StanzaField(stanza, "Package") == pkg
+		}) {
+			continue
+		}
+		sourceSuite := defaultSuite
+		if data, err := p.s3.Download(ctx, "meta/"+sourceName+"/suite"); err == nil && len(data) > 0 {
+			sourceSuite = string(data)
+		} else if err != nil && !isNotFound(err) {
+			return "", fmt.Errorf("reading suite of %s: %w", sourceName, err)
+		}
+		if sourceSuite == suite {
+			return sourceName, nil
+		}
+	}
+	return "", nil
+}
+
+// errPackageOwned is returned when an upload would compete with a package
+// published by a polled source.
+var errPackageOwned = errors.New("package is published by a polled source; force to publish anyway")
+
+// InvalidPackageError reports a package rejected by validation rather than
+// a storage or signing failure.
+type InvalidPackageError struct {
+	Err error
+}
+
+func (e *InvalidPackageError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *InvalidPackageError) Unwrap() error {
+	return e.Err
+}
+
+// errDowngrade is returned when a package is older than the published one
+// and downgrades are not allowed.
+var errDowngrade = errors.New("refusing downgrade")
+
+// errPoolConflict is returned when a pool file already exists with
+// different content than the package being published.
+var errPoolConflict = errors.New("pool file exists with different content")
+
+// publishTarget says where a package is published and which meta prefix
+// tracks it.
+type publishTarget struct {
+	sourceName string
+	suite      string
+	component  string
+}
+
+// publish validates a .deb, uploads it to the pool, records it as the
+// target's packages entry and regenerates repo metadata.
+func (p *PPA) publish(ctx context.Context, target publishTarget, debData []byte) (*DebControl, error) {
+	sourceName := target.sourceName
+
 	ctrl, err := ParseDebControl(bytes.NewReader(debData))
 	if err != nil {
-		return fmt.Errorf("parsing .deb: %w", err)
+		return nil, &InvalidPackageError{Err: fmt.Errorf("parsing .deb: %w", err)}
 	}
 
-	if !safeDebField.MatchString(ctrl.Package) || !safeDebField.MatchString(ctrl.Version) {
-		return fmt.Errorf("invalid package name %q or version %q", ctrl.Package, ctrl.Version)
+	if !safeDebField.MatchString(ctrl.Package) || !safeDebField.MatchString(ctrl.Version) || !safeDebField.MatchString(ctrl.Architecture) {
+		return nil, &InvalidPackageError{Err: fmt.Errorf("invalid package name %q, version %q or architecture %q", ctrl.Package, ctrl.Version, ctrl.Architecture)}
 	}
 
-	firstLetter := string(ctrl.Package[0])
-	filename := fmt.Sprintf("pool/%s/%s/%s-%s.deb", firstLetter, ctrl.Package, ctrl.Package, ctrl.Version)
+	if _, err := ParseVersion(ctrl.Version); err != nil {
+		return nil, &InvalidPackageError{Err: err}
+	}
+
+	// Refuse to go back in version unless forced
+	prevEntry, err := p.s3.Download(ctx, "meta/"+sourceName+"/packages-entry")
+	if err != nil && !isNotFound(err) {
+		return nil, fmt.Errorf("reading current packages entry: %w", err)
+	}
+	if prev := StanzaField(string(prevEntry), "Version"); prev != "" && CompareVersions(ctrl.Version, prev) < 0 {
+		if !p.cfg.AllowDowngrade {
+			return nil, &InvalidPackageError{Err: fmt.Errorf("%w from %s to %s", errDowngrade, prev, ctrl.Version)}
+		}
+		slog.Warn("Publishing downgrade", "source", sourceName, "from", prev, "to", ctrl.Version)
+	}
+
+	if source := strings.Fields(ctrl.Get("Source")); len(source) > 0 && !safeDebField.MatchString(source[0]) {
+		return nil, &InvalidPackageError{Err: fmt.Errorf("invalid source name %q", source[0])}
+	}
+
+	filename := PoolPath(target.component, ctrl)
 
 	md5sum := fmt.Sprintf("%x", md5.Sum(debData))
 	sha1sum := fmt.Sprintf("%x", sha1.Sum(debData))
 	sha256sum := fmt.Sprintf("%x", sha256.Sum256(debData))
 
-	slog.Info("Uploading package", "source", sourceName, "file", filename, "bytes", len(debData))
-	if err := p.s3.Upload(ctx, filename, debData, "application/vnd.debian.binary-package"); err != nil {
-		return fmt.Errorf("uploading .deb: %w", err)
+	// A changed upstream state without a new package, such as after an
+	// ETag change, must not re-sign the repository and hide its staleness.
+	if published, err := p.isPublished(ctx, target, prevEntry, filename, sha256sum); err != nil {
+		return nil, err
+	} else if published {
+		slog.Info("Package already published, nothing to do", "source", sourceName, "file", filename)
+		return ctrl, nil
+	}
+
+	// Never overwrite a published pool file: clients may have cached its hash.
+	existingSHA256, err := p.poolFileSHA256(ctx, sourceName, filename, prevEntry, ctrl.Version)
+	switch {
+	case err != nil:
+		return nil, fmt.Errorf("checking existing %s: %w", filename, err)
+	case existingSHA256 != "" && existingSHA256 != sha256sum:
+		return nil, fmt.Errorf("%s: %w", filename, errPoolConflict)
+	case existingSHA256 != "":
+		slog.Info("Package already published, skipping upload", "source", sourceName, "file", filename)
+	default:
+		slog.Info("Uploading package", "source", sourceName, "file", filename, "bytes", len(debData))
+		if err := p.s3.Upload(ctx, filename, debData, "application/vnd.debian.binary-package"); err != nil {
+			return nil, fmt.Errorf("uploading .deb: %w", err)
+		}
 	}
 
 	// Build this source's packages entry
@@ -250,39 +748,179 @@ func (p *PPA) processNewDeb(ctx context.Context, sourceName, state string, debDa
 	}
 	packagesEntry := GeneratePackagesFile([]PackageInfo{pkgInfo})
 
-	// Store source's packages entry
-	if err := p.s3.Upload(ctx, "meta/"+sourceName+"/packages-entry", packagesEntry, "text/plain"); err != nil {
-		return fmt.Errorf("uploading packages entry: %w", err)
-	}
-
-	// Lock and regenerate full repo metadata
+	// Entries are stored under the lock, so rollbacks and deletions don't
+	// interleave with them
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
+	// Store source's packages entry, where it is published, and a copy in
+	// the history for rollbacks
+	for key, data := range map[string][]byte{
+		"packages-entry":          packagesEntry,
+		"suite":                   []byte(target.suite),
+		"component":               []byte(target.component),
+		"history/" + ctrl.Version: packagesEntry,
+	} {
+		if err := p.s3.Upload(ctx, "meta/"+sourceName+"/"+key, data, "text/plain"); err != nil {
+			return nil, fmt.Errorf("uploading %s: %w", key, err)
+		}
+	}
+
+	// Regenerate full repo metadata
 	if err := p.regenerateRepoMetadata(ctx); err != nil {
-		return fmt.Errorf("regenerating repo metadata: %w", err)
+		return nil, fmt.Errorf("regenerating repo metadata: %w", err)
 	}
 
-	// Store new state
-	if state != "" {
-		if err := p.s3.Upload(ctx, "meta/"+sourceName+"/state", []byte(state), "text/plain"); err != nil {
-			return fmt.Errorf("updating state: %w", err)
+	lastPublishedTime.set(float64(time.Now().Unix()), target.suite, ctrl.Package, ctrl.Architecture)
+	return ctrl, nil
+}
+
+// isPublished reports whether the source's current packages entry already
+// lists the file with this content, in the target suite and component.
+func (p *PPA) isPublished(ctx context.Context, target publishTarget, prevEntry []byte, filename, sha256sum string) (bool, error) {
+	listed := false
+	for _, stanza := range SplitStanzas(string(prevEntry)) {
+		if StanzaField(stanza, "Filename") == filename && StanzaField(stanza, "SHA256") == sha256sum {
+			listed = true
 		}
 	}
+	if !listed {
+		return false, nil
+	}
 
-	slog.Info("Successfully processed", "source", sourceName, "package", ctrl.Package, "version", ctrl.Version)
+	for key, want := range map[string]string{"suite": target.suite, "component": target.component} {
+		data, err := p.s3.Download(ctx, "meta/"+target.sourceName+"/"+key)
+		if err != nil && !isNotFound(err) {
+			return false, fmt.Errorf("reading current %s: %w", key, err)
+		}
+		if string(data) != want {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// poolFileSHA256 returns the SHA256 of an existing pool file, or "" if there
+// is none. The hash is taken from the source's packages entry or history
+// when they list the file, so the file is only downloaded when it was
+// published by something else.
+func (p *PPA) poolFileSHA256(ctx context.Context, sourceName, filename string, prevEntry []byte, version string) (string, error) {
+	exists, err := p.s3.Exists(ctx, filename)
+	if err != nil || !exists {
+		return "", err
+	}
+
+	entries := [][]byte{prevEntry}
+	if history, err := p.s3.Download(ctx, "meta/"+sourceName+"/history/"+version); err == nil {
+		entries = append(entries, history)
+	} else if !isNotFound(err) {
+		return "", err
+	}
+	for _, entry := range entries {
+		for _, stanza := range SplitStanzas(string(entry)) {
+			if StanzaField(stanza, "Filename") == filename {
+				if sum := StanzaField(stanza, "SHA256"); sum != "" {
+					return sum, nil
+				}
+			}
+		}
+	}
+
+	existing, err := p.s3.Download(ctx, filename)
+	if isNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(existing)), nil
+}
+
+// storeState records the upstream state a source was last processed at.
+func (p *PPA) storeState(ctx context.Context, sourceName, state string) error {
+	if state == "" {
+		return nil
+	}
+	if err := p.s3.Upload(ctx, "meta/"+sourceName+"/state", []byte(state), "text/plain"); err != nil {
+		return fmt.Errorf("updating state: %w", err)
+	}
 	return nil
 }
 
 func (p *PPA) regenerateRepoMetadata(ctx context.Context) error {
+	defer regenerateSeconds.observeSince(time.Now())
+
+	suites, latest, err := p.packagesEntries(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Suites that were published before are regenerated too, so removing the
+	// last source of a suite leaves it empty instead of stale.
+	distKeys, err := p.s3.ListPrefix(ctx, "dists/")
+	if err != nil {
+		return fmt.Errorf("listing dists: %w", err)
+	}
+
+	addSuite := func(suite string) {
+		if suites[suite] == nil {
+			suites[suite] = map[string][]string{}
+		}
+	}
+	addSuite(defaultSuite)
+	for _, reg := range p.sources {
+		addSuite(reg.suite())
+	}
+	for _, key := range distKeys {
+		if suite, _, ok := strings.Cut(strings.TrimPrefix(key, "dists/"), "/"); ok {
+			addSuite(suite)
+		}
+	}
+
+	dists := map[string][]byte{}
+	for suite, components := range suites {
+		files, err := p.publishSuite(ctx, suite, components)
+		if err != nil {
+			return fmt.Errorf("publishing suite %s: %w", suite, err)
+		}
+		maps.Copy(dists, files)
+	}
+
+	if err := p.s3.Upload(ctx, "key.gpg", p.signer.PublicKey(), ""); err != nil {
+		return fmt.Errorf("uploading key.gpg: %w", err)
+	}
+
+	p.setLatestVersions(latest)
+	for _, f := range p.onRegenerate {
+		f(dists)
+	}
+	return nil
+}
+
+// packagesEntries downloads every source's packages entry. It returns the
+// stanzas grouped by suite and component, and the newest version published
+// per source.
+func (p *PPA) packagesEntries(ctx context.Context) (suites map[string]map[string][]string, latest map[string]publishedPackage, err error) {
 	// List all meta/*/packages-entry files
 	keys, err := p.s3.ListPrefix(ctx, "meta/")
 	if err != nil {
-		return fmt.Errorf("listing meta entries: %w", err)
+		return nil, nil, fmt.Errorf("listing meta entries: %w", err)
 	}
 
-	// Collect all packages entries
-	var allEntries []string
+	// readMeta returns the content of an optional meta file, or fallback.
+	readMeta := func(key, fallback string) string {
+		if !slices.Contains(keys, key) {
+			return fallback
+		}
+		data, err := p.s3.Download(ctx, key)
+		if err != nil || len(data) == 0 {
+			return fallback
+		}
+		return string(data)
+	}
+
+	suites = map[string]map[string][]string{}
+	latest = map[string]publishedPackage{}
 	for _, key := range keys {
 		if !strings.HasSuffix(key, "/packages-entry") {
 			continue
@@ -292,51 +930,140 @@ func (p *PPA) regenerateRepoMetadata(ctx context.Context) error {
 			slog.Warn("Failed to download packages entry", "key", key, "error", err)
 			continue
 		}
-		if len(data) > 0 {
-			allEntries = append(allEntries, string(data))
+		if len(data) == 0 {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(key, "/packages-entry")
+		suite := readMeta(prefix+"/suite", defaultSuite)
+		component := readMeta(prefix+"/component", defaultComponent)
+		if suites[suite] == nil {
+			suites[suite] = map[string][]string{}
+		}
+
+		sourceName := strings.TrimPrefix(prefix, "meta/")
+		for _, stanza := range SplitStanzas(string(data)) {
+			suites[suite][component] = append(suites[suite][component], stanza)
+			if v := StanzaField(stanza, "Version"); latest[sourceName].Version == "" || CompareVersions(v, latest[sourceName].Version) > 0 {
+				latest[sourceName] = publishedPackage{
+					Package:      StanzaField(stanza, "Package"),
+					Version:      v,
+					Architecture: StanzaField(stanza, "Architecture"),
+				}
+			}
 		}
 	}
+	return suites, latest, nil
+}
 
-	sort.Strings(allEntries)
-	packagesData := []byte(strings.Join(allEntries, ""))
+// publishedPackage identifies the newest package a source has published.
+type publishedPackage struct {
+	Package      string
+	Version      string
+	Architecture string
+}
 
-	packagesGz, err := GeneratePackagesGz(packagesData)
-	if err != nil {
-		return fmt.Errorf("compressing Packages: %w", err)
+func (p *PPA) setLatestVersions(latest map[string]publishedPackage) {
+	p.latestMu.Lock()
+	defer p.latestMu.Unlock()
+	p.latest = latest
+}
+
+// latestVersion returns the newest version published by a source, or "".
+func (p *PPA) latestVersion(sourceName string) string {
+	return p.latestPackage(sourceName).Version
+}
+
+// latestPackage returns the newest package published by a source, or the
+// zero value if it has published none.
+func (p *PPA) latestPackage(sourceName string) publishedPackage {
+	p.latestMu.RLock()
+	defer p.latestMu.RUnlock()
+	return p.latest[sourceName]
+}
+
+// publishSuite writes the signed Packages and Release files of one suite,
+// with one Packages index per component and architecture, and returns them
+// by key.
+func (p *PPA) publishSuite(ctx context.Context, suite string, components map[string][]string) (map[string][]byte, error) {
+	// main and amd64 are always published; packages for "all" are listed
+	// under every architecture.
+	archSet := map[string]bool{"amd64": true}
+	componentSet := map[string]bool{defaultComponent: true}
+	for component, stanzas := range components {
+		componentSet[component] = true
+		for _, stanza := range stanzas {
+			if arch := StanzaField(stanza, "Architecture"); arch != "" && arch != "all" {
+				archSet[arch] = true
+			}
+		}
 	}
+	archs := slices.Sorted(maps.Keys(archSet))
+	componentNames := slices.Sorted(maps.Keys(componentSet))
+
+	dist := "dists/" + suite
+	uploads := map[string][]byte{}
+	var hashes []FileHash
+
+	for _, component := range componentNames {
+		stanzas := components[component]
+		slices.SortFunc(stanzas, compareStanzas)
+
+		byArch := map[string][]string{}
+		for _, stanza := range stanzas {
+			switch arch := StanzaField(stanza, "Architecture"); arch {
+			case "all":
+				for _, a := range archs {
+					byArch[a] = append(byArch[a], stanza)
+				}
+			case "":
+				byArch["amd64"] = append(byArch["amd64"], stanza)
+			default:
+				byArch[arch] = append(byArch[arch], stanza)
+			}
+		}
+
+		for _, arch := range archs {
+			packagesData := []byte(strings.Join(byArch[arch], ""))
 
-	pkgHash := ComputeFileHash(packagesData)
-	pkgHash.Path = "main/binary-amd64/Packages"
+			packagesGz, err := GeneratePackagesGz(packagesData)
+			if err != nil {
+				return nil, fmt.Errorf("compressing Packages: %w", err)
+			}
 
-	gzHash := ComputeFileHash(packagesGz)
-	gzHash.Path = "main/binary-amd64/Packages.gz"
+			pkgHash := ComputeFileHash(packagesData)
+			pkgHash.Path = component + "/binary-" + arch + "/Packages"
 
-	releaseData := GenerateReleaseFile(p.cfg.Origin, p.cfg.Label, []FileHash{pkgHash, gzHash})
+			gzHash := ComputeFileHash(packagesGz)
+			gzHash.Path = component + "/binary-" + arch + "/Packages.gz"
+
+			hashes = append(hashes, pkgHash, gzHash)
+			uploads[dist+"/"+pkgHash.Path] = packagesData
+			uploads[dist+"/"+gzHash.Path] = packagesGz
+		}
+	}
+
+	releaseData := GenerateReleaseFile(p.cfg.Origin, p.cfg.Label, suite, componentNames, archs, hashes)
 
 	inRelease, err := p.signer.ClearSign(releaseData)
 	if err != nil {
-		return fmt.Errorf("clearsigning Release: %w", err)
+		return nil, fmt.Errorf("clearsigning Release: %w", err)
 	}
 
 	releaseGpg, err := p.signer.DetachedSign(releaseData)
 	if err != nil {
-		return fmt.Errorf("detach-signing Release: %w", err)
+		return nil, fmt.Errorf("detach-signing Release: %w", err)
 	}
 
-	uploads := map[string][]byte{
-		"dists/stable/main/binary-amd64/Packages":    packagesData,
-		"dists/stable/main/binary-amd64/Packages.gz": packagesGz,
-		"dists/stable/Release":                       releaseData,
-		"dists/stable/InRelease":                     inRelease,
-		"dists/stable/Release.gpg":                   releaseGpg,
-		"key.gpg":                                    p.signer.PublicKey(),
-	}
+	uploads[dist+"/Release"] = releaseData
+	uploads[dist+"/InRelease"] = inRelease
+	uploads[dist+"/Release.gpg"] = releaseGpg
 
 	for key, data := range uploads {
 		if err := p.s3.Upload(ctx, key, data, ""); err != nil {
-			return fmt.Errorf("uploading %s: %w", key, err)
+			return nil, fmt.Errorf("uploading %s: %w", key, err)
 		}
 	}
 
-	return nil
+	return uploads, nil
 }
@@ -0,0 +1,149 @@
+package ppa
+
+import (
+	"container/list"
+	"math"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit allows Requests per client in every Per interval, with bursts of
+// up to Requests. The zero value disables the limit.
+type RateLimit struct {
+	Requests int64
+	Per      time.Duration
+}
+
+func (l RateLimit) enabled() bool {
+	return l.Requests > 0 && l.Per > 0
+}
+
+// maxRateLimitBuckets bounds the memory a flood of distinct clients can
+// take. When it is reached, the least recently seen client is forgotten.
+const maxRateLimitBuckets = 100_000
+
+// rateLimiter keeps a token bucket per client. Buckets that have refilled
+// completely are forgotten, so idle clients cost nothing.
+type rateLimiter struct {
+	capacity float64
+	rate     float64 // tokens per second
+
+	mu        sync.Mutex
+	lru       *list.List // of *tokenBucket, most recently seen first
+	buckets   map[netip.Addr]*list.Element
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	client netip.Addr
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a limiter for l, or nil if l is disabled. A nil
+// limiter allows everything.
+func newRateLimiter(l RateLimit) *rateLimiter {
+	if !l.enabled() {
+		return nil
+	}
+	return &rateLimiter{
+		capacity: float64(l.Requests),
+		rate:     float64(l.Requests) / l.Per.Seconds(),
+		lru:      list.New(),
+		buckets:  map[netip.Addr]*list.Element{},
+	}
+}
+
+// allow takes a token from the client's bucket. If none is left, it returns
+// how long until one is.
+func (l *rateLimiter) allow(client netip.Addr, now time.Time) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) > time.Minute {
+		l.sweep(now)
+	}
+
+	var b *tokenBucket
+	if el, ok := l.buckets[client]; ok {
+		l.lru.MoveToFront(el)
+		b = el.Value.(*tokenBucket)
+	} else {
+		if len(l.buckets) >= maxRateLimitBuckets {
+			oldest := l.lru.Back()
+			l.lru.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*tokenBucket).client)
+		}
+		b = &tokenBucket{client: client, tokens: l.capacity, last: now}
+		l.buckets[client] = l.lru.PushFront(b)
+	}
+	b.tokens = min(l.capacity, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (l *rateLimiter) sweep(now time.Time) {
+	for client, el := range l.buckets {
+		b := el.Value.(*tokenBucket)
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.capacity {
+			l.lru.Remove(el)
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// onlyLoopback reports whether no proxies other than loopback are trusted.
+func onlyLoopback(trusted []netip.Prefix) bool {
+	for _, prefix := range trusted {
+		if !prefix.Addr().IsLoopback() {
+			return false
+		}
+	}
+	return true
+}
+
+// rateLimitKey groups IPv6 clients by /64, which is usually assigned to a
+// single site, so rotating addresses within it doesn't reset the budget.
+func rateLimitKey(addr netip.Addr) netip.Addr {
+	if addr.Is6() {
+		prefix, _ := addr.Prefix(64)
+		return prefix.Addr()
+	}
+	return addr
+}
+
+// rateLimit enforces the metadata budget on dists/ and key.gpg and the pool
+// budget on pool/. Other endpoints, such as probes and the API, are not
+// limited.
+func (s *server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var limiter *rateLimiter
+		var budget string
+		switch {
+		case strings.HasPrefix(r.URL.Path, "/pool/"):
+			limiter, budget = s.poolLimiter, "pool"
+		case strings.HasPrefix(r.URL.Path, "/dists/"), r.URL.Path == "/key.gpg":
+			limiter, budget = s.metadataLimiter, "metadata"
+		}
+
+		if ok, wait := limiter.allow(rateLimitKey(s.clientIP(r)), time.Now()); !ok {
+			rateLimited.inc(budget)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
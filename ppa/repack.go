@@ -0,0 +1,229 @@
+package ppa
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// DebPackage is an upstream .deb unpacked for modification. The control
+// fields and control members are editable. The data archive is carried over
+// unchanged unless its files are accessed through Files.
+type DebPackage struct {
+	Control *DebControl
+	// ControlFiles holds the control.tar members other than "control":
+	// maintainer scripts, md5sums, conffiles, triggers.
+	ControlFiles []DebEntry
+
+	dataName string
+	data     []byte
+	files    []DebEntry // decoded data archive, set once Files is called
+	decoded  bool
+}
+
+// UnpackDeb splits a .deb into its control fields, control members and raw
+// data archive.
+func UnpackDeb(data []byte) (*DebPackage, error) {
+	ar, err := newArReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("reading ar archive: %w", err)
+	}
+
+	pkg := &DebPackage{}
+	for {
+		header, err := ar.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading ar archive: %w", err)
+		}
+
+		name := strings.TrimRight(header.Name, "/ ")
+
+		switch {
+		case strings.HasPrefix(name, "control.tar"):
+			if err := pkg.readControlTar(ar, name); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(name, "data.tar"):
+			body, err := io.ReadAll(ar)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %w", name, err)
+			}
+			pkg.dataName = name
+			pkg.data = body
+		}
+	}
+
+	if pkg.Control == nil {
+		return nil, fmt.Errorf("control file not found in .deb")
+	}
+	if pkg.dataName == "" {
+		return nil, fmt.Errorf("data.tar not found in .deb")
+	}
+	return pkg, nil
+}
+
+func (d *DebPackage) readControlTar(r io.Reader, name string) error {
+	tr, err := openTar(r, name)
+	if err != nil {
+		return err
+	}
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("reading control tar: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		cleanName := strings.TrimPrefix(hdr.Name, "./")
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", cleanName, err)
+		}
+
+		if cleanName == "control" {
+			ctrl, err := parseControlFile(bytes.NewReader(body))
+			if err != nil {
+				return err
+			}
+			d.Control = ctrl
+			continue
+		}
+		d.ControlFiles = append(d.ControlFiles, DebEntry{
+			Path: cleanName,
+			Body: body,
+			Mode: hdr.Mode,
+		})
+	}
+}
+
+// ControlFile returns the control member with the given name (e.g.
+// "postinst"), or nil if the package has none.
+func (d *DebPackage) ControlFile(name string) *DebEntry {
+	for i := range d.ControlFiles {
+		if d.ControlFiles[i].Path == name {
+			return &d.ControlFiles[i]
+		}
+	}
+	return nil
+}
+
+// SetControlFile adds a control member, replacing one with the same name.
+func (d *DebPackage) SetControlFile(entry DebEntry) {
+	if existing := d.ControlFile(entry.Path); existing != nil {
+		*existing = entry
+		return
+	}
+	d.ControlFiles = append(d.ControlFiles, entry)
+}
+
+// Files returns the entries of the data archive, decoding it on first use.
+// Hard links are returned as regular files with their target's content.
+func (d *DebPackage) Files() ([]DebEntry, error) {
+	if d.decoded {
+		return d.files, nil
+	}
+
+	tr, err := openTar(bytes.NewReader(d.data), d.dataName)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []DebEntry
+	bodies := map[string][]byte{} // regular files by path, for hard links
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading data tar: %w", err)
+		}
+
+		// "./usr/bin/" and "usr/bin" both become "/usr/bin"
+		path := "/" + strings.Trim(strings.TrimPrefix(hdr.Name, "."), "/")
+		if path == "/" {
+			continue
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			files = append(files, DebEntry{Path: path, IsDir: true, Mode: hdr.Mode})
+		case tar.TypeSymlink:
+			files = append(files, DebEntry{Path: path, LinkTarget: hdr.Linkname, Mode: hdr.Mode})
+		case tar.TypeReg:
+			body, err := io.ReadAll(tr)
+			if err != nil {
+				return nil, fmt.Errorf("reading %s: %w", path, err)
+			}
+			files = append(files, DebEntry{Path: path, Body: body, Mode: hdr.Mode})
+			bodies[path] = body
+		case tar.TypeLink:
+			// Hard links become copies of their target when repacked.
+			target := "/" + strings.Trim(strings.TrimPrefix(hdr.Linkname, "."), "/")
+			body, ok := bodies[target]
+			if !ok {
+				return nil, fmt.Errorf("%s: hard link to unknown file %s", path, hdr.Linkname)
+			}
+			files = append(files, DebEntry{Path: path, Body: body, Mode: hdr.Mode})
+		default:
+			return nil, fmt.Errorf("%s: unsupported tar entry type %q", path, hdr.Typeflag)
+		}
+	}
+
+	d.files = files
+	d.decoded = true
+	return files, nil
+}
+
+// SetFiles replaces the entries of the data archive.
+func (d *DebPackage) SetFiles(files []DebEntry) {
+	d.files = files
+	d.decoded = true
+}
+
+// Build repacks the package into .deb bytes. A decoded data archive is
+// re-encoded as data.tar.gz with a regenerated md5sums.
+func (d *DebPackage) Build() ([]byte, error) {
+	dataName, data := d.dataName, d.data
+	if d.decoded {
+		var err error
+		data, err = buildDataTar(d.files)
+		if err != nil {
+			return nil, fmt.Errorf("building data.tar.gz: %w", err)
+		}
+		dataName = "data.tar.gz"
+		d.SetControlFile(DebEntry{Path: "md5sums", Body: md5sums(d.files), Mode: 0644})
+	}
+
+	controlTar, err := buildControlTar(*d.Control, d.ControlFiles)
+	if err != nil {
+		return nil, fmt.Errorf("building control.tar.gz: %w", err)
+	}
+	return writeDeb(controlTar, dataName, data)
+}
+
+// md5sums renders the md5sums control member for the regular files in entries.
+func md5sums(entries []DebEntry) []byte {
+	var lines []string
+	for _, e := range entries {
+		if e.IsDir || e.LinkTarget != "" {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%x  %s\n", md5.Sum(e.Body), strings.TrimPrefix(e.Path, "/")))
+	}
+	sort.Strings(lines)
+	return []byte(strings.Join(lines, ""))
+}
@@ -7,6 +7,7 @@ import (
 	"crypto/sha1"
 	"crypto/sha256"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -58,14 +59,14 @@ type FileHash struct {
 	SHA256 string
 }
 
-func GenerateReleaseFile(origin, label string, files []FileHash) []byte {
+func GenerateReleaseFile(origin, label, suite string, components, archs []string, files []FileHash) []byte {
 	var buf bytes.Buffer
 	fmt.Fprintf(&buf, "Origin: %s\n", origin)
 	fmt.Fprintf(&buf, "Label: %s\n", label)
-	fmt.Fprintf(&buf, "Suite: stable\n")
-	fmt.Fprintf(&buf, "Codename: stable\n")
-	fmt.Fprintf(&buf, "Architectures: amd64\n")
-	fmt.Fprintf(&buf, "Components: main\n")
+	fmt.Fprintf(&buf, "Suite: %s\n", suite)
+	fmt.Fprintf(&buf, "Codename: %s\n", suite)
+	fmt.Fprintf(&buf, "Architectures: %s\n", strings.Join(archs, " "))
+	fmt.Fprintf(&buf, "Components: %s\n", strings.Join(components, " "))
 	fmt.Fprintf(&buf, "Date: %s\n", time.Now().UTC().Format(time.RFC1123))
 
 	fmt.Fprintf(&buf, "MD5Sum:\n")
@@ -86,6 +87,64 @@ func GenerateReleaseFile(origin, label string, files []FileHash) []byte {
 	return buf.Bytes()
 }
 
+// PoolPath returns the Debian pool location of a package:
+// pool/<component>/<prefix>/<source>/<package>_<version>_<arch>.deb, where
+// prefix is "libx" for lib* sources and the first letter otherwise, and the
+// version has its epoch stripped.
+func PoolPath(component string, ctrl *DebControl) string {
+	source := ctrl.Package
+	if fields := strings.Fields(ctrl.Get("Source")); len(fields) > 0 {
+		// "Source: name (version)" when the source version differs
+		source = fields[0]
+	}
+
+	prefix := source[:1]
+	if strings.HasPrefix(source, "lib") && len(source) > 3 {
+		prefix = source[:4]
+	}
+
+	version := ctrl.Version
+	if _, rest, ok := strings.Cut(version, ":"); ok {
+		version = rest
+	}
+
+	return fmt.Sprintf("pool/%s/%s/%s/%s_%s_%s.deb", component, prefix, source, ctrl.Package, version, ctrl.Architecture)
+}
+
+// SplitStanzas splits a Packages file into its stanzas, each terminated by a
+// blank line.
+func SplitStanzas(data string) []string {
+	var stanzas []string
+	for _, stanza := range strings.Split(data, "\n\n") {
+		if stanza = strings.Trim(stanza, "\n"); stanza != "" {
+			stanzas = append(stanzas, stanza+"\n\n")
+		}
+	}
+	return stanzas
+}
+
+// compareStanzas orders Packages stanzas by package name, then by Debian
+// version, then by architecture.
+func compareStanzas(a, b string) int {
+	if c := strings.Compare(StanzaField(a, "Package"), StanzaField(b, "Package")); c != 0 {
+		return c
+	}
+	if c := CompareVersions(StanzaField(a, "Version"), StanzaField(b, "Version")); c != 0 {
+		return c
+	}
+	return strings.Compare(StanzaField(a, "Architecture"), StanzaField(b, "Architecture"))
+}
+
+// StanzaField returns the value of a single-line field in a Packages stanza.
+func StanzaField(stanza, key string) string {
+	for _, line := range strings.Split(stanza, "\n") {
+		if v, ok := strings.CutPrefix(line, key+": "); ok {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
 func ComputeFileHash(data []byte) FileHash {
 	return FileHash{
 		Size:   len(data),
@@ -3,18 +3,25 @@ package ppa
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
 )
 
 type S3Client struct {
-	client *s3.Client
-	bucket string
+	client  *s3.Client
+	presign *s3.PresignClient
+	bucket  string
 }
 
 type S3Config struct {
@@ -37,7 +44,7 @@ func NewS3Client(cfg S3Config) *S3Client {
 		Credentials:  credentials.NewStaticCredentialsProvider(cfg.AccessKey, cfg.SecretKey, ""),
 		UsePathStyle: true,
 	})
-	return &S3Client{client: client, bucket: cfg.Bucket}
+	return &S3Client{client: client, presign: s3.NewPresignClient(client), bucket: cfg.Bucket}
 }
 
 func (s *S3Client) Upload(ctx context.Context, key string, data []byte, contentType string) error {
@@ -51,6 +58,7 @@ func (s *S3Client) Upload(ctx context.Context, key string, data []byte, contentT
 	}
 	_, err := s.client.PutObject(ctx, input)
 	if err != nil {
+		countStorageError("upload", err)
 		return fmt.Errorf("uploading %s: %w", key, err)
 	}
 	return nil
@@ -62,17 +70,124 @@ func (s *S3Client) Download(ctx context.Context, key string) ([]byte, error) {
 		Key:    &key,
 	})
 	if err != nil {
+		countStorageError("download", err)
 		return nil, fmt.Errorf("downloading %s: %w", key, err)
 	}
 	defer output.Body.Close()
 	return io.ReadAll(output.Body)
 }
 
-func (s *S3Client) GetObject(ctx context.Context, key string) (*s3.GetObjectOutput, error) {
-	return s.client.GetObject(ctx, &s3.GetObjectInput{
+// GetOptions are conditions and a byte range forwarded to storage with a
+// GetObject or HeadObject request.
+type GetOptions struct {
+	IfNoneMatch       string
+	IfModifiedSince   time.Time
+	IfMatch           string
+	IfUnmodifiedSince time.Time
+	Range             string // e.g. "bytes=100-"
+}
+
+// GetObject streams an object or the requested range of it. When an
+// If-None-Match or If-Modified-Since condition matches, the returned error
+// satisfies notModified.
+func (s *S3Client) GetObject(ctx context.Context, key string, opts GetOptions) (*s3.GetObjectOutput, error) {
+	input := &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	}
+	if opts.IfNoneMatch != "" {
+		input.IfNoneMatch = &opts.IfNoneMatch
+	}
+	if !opts.IfModifiedSince.IsZero() {
+		input.IfModifiedSince = &opts.IfModifiedSince
+	}
+	if opts.IfMatch != "" {
+		input.IfMatch = &opts.IfMatch
+	}
+	if !opts.IfUnmodifiedSince.IsZero() {
+		input.IfUnmodifiedSince = &opts.IfUnmodifiedSince
+	}
+	if opts.Range != "" {
+		input.Range = &opts.Range
+	}
+	output, err := s.client.GetObject(ctx, input)
+	if err != nil {
+		countStorageError("get", err)
+	}
+	return output, err
+}
+
+// HeadObject returns an object's metadata, evaluating opts like GetObject.
+func (s *S3Client) HeadObject(ctx context.Context, key string, opts GetOptions) (*s3.HeadObjectOutput, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	}
+	if opts.IfNoneMatch != "" {
+		input.IfNoneMatch = &opts.IfNoneMatch
+	}
+	if !opts.IfModifiedSince.IsZero() {
+		input.IfModifiedSince = &opts.IfModifiedSince
+	}
+	if opts.IfMatch != "" {
+		input.IfMatch = &opts.IfMatch
+	}
+	if !opts.IfUnmodifiedSince.IsZero() {
+		input.IfUnmodifiedSince = &opts.IfUnmodifiedSince
+	}
+	if opts.Range != "" {
+		input.Range = &opts.Range
+	}
+	output, err := s.client.HeadObject(ctx, input)
+	if err != nil {
+		countStorageError("head", err)
+	}
+	return output, err
+}
+
+// Exists reports whether an object exists, without downloading it.
+func (s *S3Client) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: &s.bucket,
 		Key:    &key,
 	})
+	if isNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		countStorageError("head", err)
+		return false, fmt.Errorf("checking %s: %w", key, err)
+	}
+	return true, nil
+}
+
+// PresignGet returns a URL that downloads an object without credentials
+// until ttl elapses.
+func (s *S3Client) PresignGet(ctx context.Context, key string, ttl time.Duration) (string, error) {
+	req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	}, s3.WithPresignExpires(ttl))
+	if err != nil {
+		return "", fmt.Errorf("presigning %s: %w", key, err)
+	}
+	return req.URL, nil
+}
+
+// LastModified returns when an object was last written.
+func (s *S3Client) LastModified(ctx context.Context, key string) (time.Time, error) {
+	output, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: &s.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		countStorageError("head", err)
+		return time.Time{}, fmt.Errorf("checking %s: %w", key, err)
+	}
+	if output.LastModified == nil {
+		return time.Time{}, fmt.Errorf("checking %s: no Last-Modified", key)
+	}
+	return *output.LastModified, nil
 }
 
 func (s *S3Client) Delete(ctx context.Context, key string) error {
@@ -81,6 +196,7 @@ func (s *S3Client) Delete(ctx context.Context, key string) error {
 		Key:    &key,
 	})
 	if err != nil {
+		countStorageError("delete", err)
 		return fmt.Errorf("deleting %s: %w", key, err)
 	}
 	return nil
@@ -95,6 +211,7 @@ func (s *S3Client) ListPrefix(ctx context.Context, prefix string) ([]string, err
 	for paginator.HasMorePages() {
 		page, err := paginator.NextPage(ctx)
 		if err != nil {
+			countStorageError("list", err)
 			return nil, fmt.Errorf("listing %s: %w", prefix, err)
 		}
 		for _, obj := range page.Contents {
@@ -103,3 +220,87 @@ func (s *S3Client) ListPrefix(ctx context.Context, prefix string) ([]string, err
 	}
 	return keys, nil
 }
+
+// isNotFound reports whether err means the requested object does not exist.
+func isNotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	var notFound *types.NotFound
+	if errors.As(err, &noSuchKey) || errors.As(err, &notFound) {
+		return true
+	}
+	var respErr *awshttp.ResponseError
+	return errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotFound
+}
+
+// notModified returns the storage response to a conditional request whose
+// condition matched, or nil if err reports anything else.
+func notModified(err error) *http.Response {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified && respErr.Response != nil {
+		return respErr.Response.Response
+	}
+	return nil
+}
+
+// storageStatus returns the HTTP status code of a storage error response,
+// or 0 if err did not come from a storage response.
+func storageStatus(err error) int {
+	var respErr *awshttp.ResponseError
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode()
+	}
+	return 0
+}
+
+// Kinds of storage errors, as returned by classifyStorageError.
+const (
+	storageNotFound     = "not_found"
+	storageCanceled     = "canceled"
+	storageAccessDenied = "access_denied"
+	storageTimeout      = "timeout"
+	storageUnavailable  = "unavailable"
+	storageOther        = "other"
+)
+
+// classifyStorageError tells missing objects apart from credential
+// problems, timeouts and outages.
+func classifyStorageError(err error) string {
+	switch {
+	case isNotFound(err):
+		return storageNotFound
+	case errors.Is(err, context.Canceled):
+		return storageCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return storageTimeout
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return storageTimeout
+	}
+
+	switch status := storageStatus(err); {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return storageAccessDenied
+	case status >= 500:
+		return storageUnavailable
+	case status == 0:
+		// No response at all: connection refused, DNS failure and the like.
+		return storageUnavailable
+	}
+	return storageOther
+}
+
+// countStorageError records a failed storage operation in the metrics.
+// Missing objects, matched conditions, unsatisfiable ranges and requests
+// canceled by the client are expected and not counted.
+func countStorageError(operation string, err error) {
+	switch storageStatus(err) {
+	case http.StatusNotModified, http.StatusPreconditionFailed, http.StatusRequestedRangeNotSatisfiable:
+		return
+	}
+	switch kind := classifyStorageError(err); kind {
+	case storageNotFound, storageCanceled:
+	default:
+		storageErrors.inc(operation, kind)
+	}
+}
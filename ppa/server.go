@@ -1,36 +1,111 @@
 package ppa
 
 import (
+	"bytes"
 	"fmt"
 	"html"
 	"io"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"strings"
+	"time"
 )
 
 type sourceInfo struct {
 	Name        string
 	Description string
+	Suite       string
 }
 
 type server struct {
+	ppa        *PPA
 	s3         *S3Client
 	signer     *GPGSigner
 	sources    []sourceInfo
 	maintainer string
+	apiTokens  []string
+
+	webhookSecret string
+	readyMaxAge   time.Duration
+
+	poolRedirect   string
+	poolPresignTTL time.Duration
+	poolCDNBaseURL string
+
+	cache    *objectCache
+	snapshot distsSnapshot
+	stats    *downloadStats
+
+	accessLogEnabled  bool
+	accessLogParseAPT bool
+	trustedProxies    []netip.Prefix
+
+	metadataLimiter *rateLimiter
+	poolLimiter     *rateLimiter
 }
 
-func newServer(s3 *S3Client, signer *GPGSigner, sources []sourceInfo, maintainer string) *server {
-	return &server{s3: s3, signer: signer, sources: sources, maintainer: maintainer}
+func newServer(p *PPA, sources []sourceInfo) *server {
+	s := &server{
+		ppa:        p,
+		s3:         p.s3,
+		signer:     p.signer,
+		sources:    sources,
+		maintainer: p.cfg.Maintainer,
+		apiTokens:  p.cfg.APITokens,
+
+		webhookSecret: p.cfg.GitHubWebhookSecret,
+		readyMaxAge:   p.cfg.ReadyMaxAge,
+
+		poolRedirect:   p.cfg.PoolRedirect,
+		poolPresignTTL: p.cfg.PoolPresignTTL,
+		poolCDNBaseURL: strings.TrimSuffix(p.cfg.PoolCDNBaseURL, "/"),
+
+		cache: newObjectCache(p.cfg.CacheSize, p.cfg.CacheMetadataTTL),
+		stats: newDownloadStats(p.cfg.StatsSalt),
+
+		accessLogEnabled:  p.cfg.AccessLog,
+		accessLogParseAPT: p.cfg.AccessLogParseAPT,
+		trustedProxies:    p.cfg.TrustedProxies,
+
+		metadataLimiter: newRateLimiter(p.cfg.RateLimitMetadata),
+		poolLimiter:     newRateLimiter(p.cfg.RateLimitPool),
+	}
+	p.onRegenerate = append(p.onRegenerate, func(dists map[string][]byte) {
+		s.cache.invalidateMetadata()
+		s.snapshot.replace(dists)
+	})
+	return s
 }
 
 func (s *server) handler() http.Handler {
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /key.gpg", s.handleKeyGPG)
 	mux.HandleFunc("GET /dists/", s.handleProxy)
-	mux.HandleFunc("GET /pool/", s.handleProxy)
+	mux.Handle("GET /pool/", s.countDownloads(http.HandlerFunc(s.handleProxy)))
+	mux.HandleFunc("GET /healthz", s.handleHealthz)
+	mux.HandleFunc("GET /readyz", s.handleReadyz)
+	mux.HandleFunc("GET /status", s.handleStatus)
+	mux.HandleFunc("GET /metrics", s.handleMetrics)
 	mux.HandleFunc("GET /{$}", s.handleIndex)
-	return mux
+	mux.HandleFunc("POST /api/packages", s.requireToken(s.handlePublish))
+	// Source names containing "/", such as uploads, must be escaped as %2F.
+	mux.HandleFunc("GET /api/sources", s.requireToken(s.handleListSources))
+	mux.HandleFunc("DELETE /api/sources/{name}", s.requireToken(s.handleDeleteSource))
+	mux.HandleFunc("POST /api/sources/{name}/poll", s.requireToken(s.handlePoll))
+	mux.HandleFunc("POST /api/sources/{name}/rollback", s.requireToken(s.handleRollback))
+	mux.HandleFunc("POST /api/regenerate", s.requireToken(s.handleRegenerate))
+	mux.HandleFunc("GET /api/stats", s.requireToken(s.handleStats))
+	mux.HandleFunc("POST /api/webhooks/github", s.handleGitHubWebhook) // authenticated by HMAC signature
+	var handler http.Handler = mux
+	if s.metadataLimiter != nil || s.poolLimiter != nil {
+		handler = s.rateLimit(handler)
+	}
+	handler = instrument(handler)
+	if s.accessLogEnabled {
+		handler = s.accessLog(handler)
+	}
+	return handler
 }
 
 func (s *server) handleKeyGPG(w http.ResponseWriter, r *http.Request) {
@@ -38,6 +113,22 @@ func (s *server) handleKeyGPG(w http.ResponseWriter, r *http.Request) {
 	w.Write(s.signer.PublicKey())
 }
 
+// Cache policies for proxied files. Pool files and by-hash indexes never
+// change once published. The other dists files change on every
+// regeneration and must stay consistent with InRelease, so caches keep them
+// briefly and revalidate.
+const (
+	cacheImmutable = "public, max-age=31536000, immutable"
+	cacheMetadata  = "public, max-age=60, must-revalidate"
+)
+
+func cacheControl(key string) string {
+	if strings.HasPrefix(key, "pool/") || strings.Contains(key, "/by-hash/") {
+		return cacheImmutable
+	}
+	return cacheMetadata
+}
+
 func (s *server) handleProxy(w http.ResponseWriter, r *http.Request) {
 	key := strings.TrimPrefix(r.URL.Path, "/")
 	if strings.Contains(key, "..") {
@@ -45,21 +136,251 @@ func (s *server) handleProxy(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	output, err := s.s3.GetObject(r.Context(), key)
+	if s.poolRedirect != "" && strings.HasPrefix(key, "pool/") {
+		s.redirectPool(w, r, key)
+		return
+	}
+
+	if obj, revalidate := s.cache.get(key); obj != nil && (!revalidate || s.revalidate(r, obj)) {
+		s.serveCached(w, r, obj)
+		return
+	}
+	if s.cache != nil && strings.HasPrefix(key, "dists/") {
+		// Metadata is small and hot: always cache the whole object.
+		obj, err := s.fillCache(r, key)
+		if err == nil {
+			s.serveCached(w, r, obj)
+			return
+		}
+		s.serveStorageError(w, r, key, err)
+		return
+	}
+
+	opts := requestOptions(r)
+	meta, body, err := s.fetchObject(r, key, opts)
+	if storageStatus(err) == http.StatusPreconditionFailed && opts.Range != "" {
+		// If-Range did not match: send the whole current object.
+		opts.Range, opts.IfMatch, opts.IfUnmodifiedSince = "", "", time.Time{}
+		meta, body, err = s.fetchObject(r, key, opts)
+	}
+	if resp := notModified(err); resp != nil {
+		w.Header().Set("Cache-Control", cacheControl(key))
+		for _, h := range []string{"ETag", "Last-Modified"} {
+			if v := resp.Header.Get(h); v != "" {
+				w.Header().Set(h, v)
+			}
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if storageStatus(err) == http.StatusRequestedRangeNotSatisfiable {
+		// RFC 9110 requires the current length with a 416.
+		if head, err := s.s3.HeadObject(r.Context(), key, GetOptions{}); err == nil && head.ContentLength != nil {
+			w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", *head.ContentLength))
+		}
+		http.Error(w, "Requested Range Not Satisfiable", http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
 	if err != nil {
+		s.serveStorageError(w, r, key, err)
+		return
+	}
+	if body != nil {
+		defer body.Close()
+	}
+
+	h := w.Header()
+	h.Set("Accept-Ranges", "bytes")
+	h.Set("Cache-Control", cacheControl(key))
+	if meta.etag != nil {
+		h.Set("ETag", *meta.etag)
+	}
+	if meta.lastModified != nil {
+		h.Set("Last-Modified", meta.lastModified.UTC().Format(http.TimeFormat))
+	}
+	if meta.contentType != nil {
+		h.Set("Content-Type", *meta.contentType)
+	}
+	if meta.contentLength != nil {
+		h.Set("Content-Length", fmt.Sprintf("%d", *meta.contentLength))
+	}
+
+	status := http.StatusOK
+	if meta.contentRange != nil {
+		h.Set("Content-Range", *meta.contentRange)
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+
+	if body == nil {
+		return
+	}
+	if status != http.StatusOK || meta.contentLength == nil || !s.cache.fits(*meta.contentLength) {
+		io.Copy(w, body)
+		return
+	}
+
+	// Keep a copy of complete small responses for later requests.
+	var buf bytes.Buffer
+	if n, err := io.Copy(w, io.TeeReader(body, &buf)); err == nil && n == *meta.contentLength {
+		obj := &cachedObject{key: key, data: buf.Bytes()}
+		if meta.etag != nil {
+			obj.etag = *meta.etag
+		}
+		if meta.contentType != nil {
+			obj.contentType = *meta.contentType
+		}
+		if meta.lastModified != nil {
+			obj.lastModified = *meta.lastModified
+		}
+		s.cache.put(obj)
+	}
+}
+
+// storageRetryAfter is the Retry-After sent while storage is unavailable.
+const storageRetryAfter = "30"
+
+// serveStorageError answers a request whose storage lookup failed: 404 for
+// missing objects. Otherwise storage is failing, so dists/ files come from
+// the snapshot and everything else gets 502 for credential and unexpected
+// errors or 503 for outages and timeouts.
+func (s *server) serveStorageError(w http.ResponseWriter, r *http.Request, key string, err error) {
+	kind := classifyStorageError(err)
+	switch kind {
+	case storageNotFound:
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
+	case storageCanceled:
+		return // the client is gone
+	}
+
+	if f, ok := s.snapshot.get(key); ok && strings.HasPrefix(key, "dists/") {
+		slog.Warn("Storage failed, serving metadata snapshot",
+			"method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, "kind", kind, "error", err)
+		w.Header().Set("Cache-Control", cacheControl(key))
+		w.Header().Set("ETag", f.etag)
+		http.ServeContent(w, r, "", f.modTime, bytes.NewReader(f.data))
+		return
+	}
+
+	status := http.StatusBadGateway
+	if kind == storageUnavailable || kind == storageTimeout {
+		status = http.StatusServiceUnavailable
+		w.Header().Set("Retry-After", storageRetryAfter)
+	}
+	slog.Error("Storage request failed",
+		"method", r.Method, "path", r.URL.Path, "remote", r.RemoteAddr, "kind", kind, "status", status, "error", err)
+	http.Error(w, http.StatusText(status), status)
+}
+
+// redirectPool sends a pool download to storage or the CDN directly, so
+// package bytes bypass the server. Only dists/ stays proxied.
+func (s *server) redirectPool(w http.ResponseWriter, r *http.Request, key string) {
+	var target string
+	switch s.poolRedirect {
+	case "cdn":
+		target = s.poolCDNBaseURL + "/" + key
+		w.Header().Set("Cache-Control", "public, max-age=86400")
+	case "presign":
+		url, err := s.s3.PresignGet(r.Context(), key, s.poolPresignTTL)
+		if err != nil {
+			slog.Error("Presigning pool file failed", "key", key, "error", err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		target = url
+		// The signature expires, so the redirect must not be cached.
+		w.Header().Set("Cache-Control", "no-store")
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// requestOptions translates a request's conditional and Range headers into
+// storage request options.
+func requestOptions(r *http.Request) GetOptions {
+	// If-None-Match takes precedence over If-Modified-Since (RFC 9110).
+	opts := GetOptions{IfNoneMatch: r.Header.Get("If-None-Match")}
+	if opts.IfNoneMatch == "" {
+		if t, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+			opts.IfModifiedSince = t
+		}
+	}
+
+	opts.Range = r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && opts.Range != "" {
+		// Storage has no If-Range. Sending the range only while the
+		// validator still holds fails with 412 otherwise, and the caller
+		// then retries for the whole object.
+		switch {
+		case strings.HasPrefix(ifRange, "W/"):
+			opts.Range = "" // weak validators never match If-Range
+		case strings.HasPrefix(ifRange, `"`):
+			opts.IfMatch = ifRange
+		default:
+			if t, err := http.ParseTime(ifRange); err == nil {
+				opts.IfUnmodifiedSince = t
+			} else {
+				opts.Range = ""
+			}
+		}
 	}
-	defer output.Body.Close()
+	return opts
+}
+
+// objectMeta holds the storage response fields forwarded to clients.
+type objectMeta struct {
+	etag, contentType, contentRange *string
+	lastModified                    *time.Time
+	contentLength                   *int64
+}
 
-	if output.ContentType != nil {
-		w.Header().Set("Content-Type", *output.ContentType)
+// fetchObject gets an object, or only its metadata for HEAD requests, in
+// which case the returned body is nil.
+func (s *server) fetchObject(r *http.Request, key string, opts GetOptions) (objectMeta, io.ReadCloser, error) {
+	if r.Method == http.MethodHead {
+		output, err := s.s3.HeadObject(r.Context(), key, opts)
+		if err != nil {
+			return objectMeta{}, nil, err
+		}
+		return objectMeta{
+			etag:          output.ETag,
+			contentType:   output.ContentType,
+			contentRange:  output.ContentRange,
+			lastModified:  output.LastModified,
+			contentLength: output.ContentLength,
+		}, nil, nil
 	}
-	if output.ContentLength != nil {
-		w.Header().Set("Content-Length", fmt.Sprintf("%d", *output.ContentLength))
+
+	output, err := s.s3.GetObject(r.Context(), key, opts)
+	if err != nil {
+		return objectMeta{}, nil, err
 	}
+	return objectMeta{
+		etag:          output.ETag,
+		contentType:   output.ContentType,
+		contentRange:  output.ContentRange,
+		lastModified:  output.LastModified,
+		contentLength: output.ContentLength,
+	}, output.Body, nil
+}
 
-	io.Copy(w, output.Body)
+// handleStatus reports the outcome of recent polls of every registered source.
+func (s *server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	type sourceStatusEntry struct {
+		Name  string `json:"name"`
+		Suite string `json:"suite"`
+		sourceStatus
+	}
+
+	entries := make([]sourceStatusEntry, 0, len(s.sources))
+	for _, src := range s.sources {
+		status, _ := s.ppa.sourceStatusOf(src.Name)
+		if status.Version == "" {
+			status.Version = s.ppa.latestVersion(src.Name)
+		}
+		entries = append(entries, sourceStatusEntry{Name: src.Name, Suite: src.Suite, sourceStatus: status})
+	}
+	writeJSON(w, http.StatusOK, entries)
 }
 
 func (s *server) handleIndex(w http.ResponseWriter, r *http.Request) {
@@ -86,11 +407,54 @@ func formatMaintainer(m string) string {
 	return html.EscapeString(m)
 }
 
+// statusHTML summarizes a source's recent polls for the index page.
+func (s *server) statusHTML(sourceName string) string {
+	status, ok := s.ppa.sourceStatusOf(sourceName)
+	if !ok || status.LastCheck.IsZero() {
+		return ""
+	}
+
+	const layout = "2006-01-02 15:04 UTC"
+	line := "Last checked " + status.LastCheck.Format(layout)
+	if !status.NextPoll.IsZero() {
+		line += ", next check " + status.NextPoll.Format(layout)
+	}
+	if status.ConsecutiveFailures > 0 {
+		lastSuccess := "never"
+		if !status.LastSuccess.IsZero() {
+			lastSuccess = status.LastSuccess.Format(layout)
+		}
+		line += fmt.Sprintf(". <strong>Failing</strong> (%d in a row, last success %s): %s",
+			status.ConsecutiveFailures, lastSuccess, html.EscapeString(status.LastError))
+	}
+	if status.Unverified != "" {
+		line += ". <strong>Published unverified</strong>: " + html.EscapeString(status.Unverified)
+	}
+	return "<br><small>" + line + "</small>"
+}
+
 func (s *server) indexHTML() string {
-	var packageList strings.Builder
+	var packageList, suiteSetup strings.Builder
+	seenSuites := map[string]bool{defaultSuite: true}
 	for _, src := range s.sources {
-		fmt.Fprintf(&packageList, "<dt><code>%s</code></dt>\n<dd>%s</dd>\n",
-			html.EscapeString(src.Name), html.EscapeString(src.Description))
+		// Sources are named after their package, but one package may come
+		// from several sources, such as one per architecture.
+		name, suffix := src.Name, ""
+		if pkg := s.ppa.latestPackage(src.Name); pkg.Version != "" {
+			name = pkg.Package
+			suffix = fmt.Sprintf(" %s (%s)", html.EscapeString(pkg.Version), html.EscapeString(pkg.Architecture))
+		}
+		if src.Suite != defaultSuite {
+			suffix += fmt.Sprintf(" (suite <code>%s</code>)", html.EscapeString(src.Suite))
+		}
+		fmt.Fprintf(&packageList, "<dt><code>%s</code>%s</dt>\n<dd>%s%s</dd>\n",
+			html.EscapeString(name), suffix, html.EscapeString(src.Description), s.statusHTML(src.Name))
+
+		if !seenSuites[src.Suite] {
+			seenSuites[src.Suite] = true
+			fmt.Fprintf(&suiteSetup, "\n# Packages in the %[1]s suite\necho \"deb [arch=$(dpkg --print-architecture) signed-by=/usr/share/keyrings/ppa.gpg] https://ppa.matejpavlicek.cz %[1]s main\" | sudo tee /etc/apt/sources.list.d/matej-pavlicek-ppa-%[1]s.list\n",
+				html.EscapeString(src.Suite))
+		}
 	}
 
 	return `<!DOCTYPE html>
@@ -103,14 +467,14 @@ func (s *server) indexHTML() string {
 <h2>Available packages</h2>
 <dl>
 ` + packageList.String() + `</dl>
-<h2>Setup</h2>
+` + s.statsHTML() + `<h2>Setup</h2>
 <pre>
 # Download the signing key
 curl -fsSL https://ppa.matejpavlicek.cz/key.gpg | sudo gpg --dearmor -o /usr/share/keyrings/ppa.gpg
 
 # Add the repository
-echo "deb [arch=amd64 signed-by=/usr/share/keyrings/ppa.gpg] https://ppa.matejpavlicek.cz stable main" | sudo tee /etc/apt/sources.list.d/matej-pavlicek-ppa.list
-
+echo "deb [arch=$(dpkg --print-architecture) signed-by=/usr/share/keyrings/ppa.gpg] https://ppa.matejpavlicek.cz stable main" | sudo tee /etc/apt/sources.list.d/matej-pavlicek-ppa.list
+` + suiteSetup.String() + `
 # Update and install
 sudo apt update
 sudo apt install &lt;package-name&gt;
@@ -0,0 +1,119 @@
+package ppa
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// distsSnapshot keeps the last published dists/ files in memory so apt
+// clients can still update while storage is unavailable.
+type distsSnapshot struct {
+	mu    sync.RWMutex
+	files map[string]snapshotFile
+	pool  map[string]bool // pool files listed in the Packages indexes
+}
+
+type snapshotFile struct {
+	data    []byte
+	etag    string
+	modTime time.Time
+}
+
+// newSnapshotFile describes data the way storage does, with the MD5 ETag
+// of a single-part upload, so validators stay the same across failover.
+func newSnapshotFile(data []byte, modTime time.Time) snapshotFile {
+	return snapshotFile{
+		data:    data,
+		etag:    fmt.Sprintf(`"%x"`, md5.Sum(data)),
+		modTime: modTime,
+	}
+}
+
+// replace swaps in the files published by a regeneration.
+func (d *distsSnapshot) replace(dists map[string][]byte) {
+	now := time.Now()
+	files := make(map[string]snapshotFile, len(dists))
+	for key, data := range dists {
+		files[key] = newSnapshotFile(data, now)
+	}
+
+	pool := poolFiles(files)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.files = files
+	d.pool = pool
+}
+
+func (d *distsSnapshot) get(key string) (snapshotFile, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	f, ok := d.files[key]
+	return f, ok
+}
+
+// published reports whether a pool file is listed in the published indexes.
+func (d *distsSnapshot) published(key string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.pool[key]
+}
+
+// poolFiles collects the Filename fields of the uncompressed Packages
+// indexes.
+func poolFiles(files map[string]snapshotFile) map[string]bool {
+	pool := map[string]bool{}
+	for key, f := range files {
+		if path.Base(key) != "Packages" {
+			continue
+		}
+		for line := range strings.Lines(string(f.data)) {
+			if filename, ok := strings.CutPrefix(line, "Filename: "); ok {
+				pool[strings.TrimSpace(filename)] = true
+			}
+		}
+	}
+	return pool
+}
+
+// load fills the snapshot from storage at startup.
+func (d *distsSnapshot) load(ctx context.Context, s3 *S3Client) error {
+	keys, err := s3.ListPrefix(ctx, "dists/")
+	if err != nil {
+		return err
+	}
+
+	files := map[string]snapshotFile{}
+	for _, key := range keys {
+		output, err := s3.GetObject(ctx, key, GetOptions{})
+		if err != nil {
+			return fmt.Errorf("downloading %s: %w", key, err)
+		}
+		data, err := io.ReadAll(output.Body)
+		output.Body.Close()
+		if err != nil {
+			return fmt.Errorf("downloading %s: %w", key, err)
+		}
+
+		modTime := time.Now()
+		if output.LastModified != nil {
+			modTime = *output.LastModified
+		}
+		files[key] = newSnapshotFile(data, modTime)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.files == nil {
+		// A regeneration that finished meanwhile is newer; keep it.
+		d.files = files
+		d.pool = poolFiles(files)
+	}
+	return nil
+}
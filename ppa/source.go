@@ -15,7 +15,27 @@ type Source interface {
 	// The PPA compares this with the previously stored state to detect changes.
 	Check(ctx context.Context) (state string, err error)
 
-	// Fetch downloads or builds the .deb package bytes.
+	// Fetch downloads or builds the .deb package bytes, or downloads the
+	// upstream artifact of a Builder.
 	// Called only when Check returns a different state than stored.
 	Fetch(ctx context.Context) (deb []byte, err error)
 }
+
+// Builder is implemented by sources whose Fetch returns an upstream
+// artifact that still has to be turned into a .deb, such as a tarball. The
+// artifact is verified against the registration's Verification before
+// Build is called.
+type Builder interface {
+	Source
+
+	Build(artifact []byte) (deb []byte, err error)
+}
+
+// GitHubSource is implemented by sources that track releases of a GitHub
+// repository, so release webhooks from that repository can trigger a poll.
+type GitHubSource interface {
+	Source
+
+	// GitHubRepo returns the repository in "owner/repo" format.
+	GitHubRepo() string
+}
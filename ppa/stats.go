@@ -0,0 +1,376 @@
+package ppa
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"log/slog"
+	"maps"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsFlushInterval is how often download statistics are written to storage.
+const statsFlushInterval = 5 * time.Minute
+
+// downloadStats counts pool downloads per package file and unique clients
+// per package and day. Clients are identified by a hash of their IP and the
+// date keyed with a salt that is never written to storage, so the stored
+// hashes can't be reversed by reading the bucket. Aggregates are persisted
+// under stats/: totals.json and daily/<date>.json.
+type downloadStats struct {
+	salt []byte // set before the server starts and never changed
+
+	mu     sync.Mutex
+	loaded bool                 // the persisted totals have been read, so flushing doesn't overwrite them
+	totals map[string]int64     // "<package>_<version>_<arch>" -> downloads
+	days   map[string]*dayStats // date -> stats of today and of days not yet flushed
+	dirty  bool
+}
+
+// newDownloadStats returns empty statistics hashing clients with salt, or
+// with a random salt if it is empty. A random salt changes on restart, so
+// clients downloading before and after it count twice that day.
+func newDownloadStats(salt string) *downloadStats {
+	d := &downloadStats{salt: []byte(salt)}
+	if salt == "" {
+		d.salt = make([]byte, 32)
+		rand.Read(d.salt)
+	}
+	return d
+}
+
+type dayStats struct {
+	Downloads map[string]int64           `json:"downloads"` // "<package>_<version>_<arch>" -> downloads
+	Clients   map[string]map[string]bool `json:"clients"`   // package -> client hashes
+}
+
+func newDayStats() *dayStats {
+	return &dayStats{Downloads: map[string]int64{}, Clients: map[string]map[string]bool{}}
+}
+
+// load reads the totals and today's stats from storage.
+func (d *downloadStats) load(ctx context.Context, s3 *S3Client) error {
+	totals := map[string]int64{}
+	if data, err := s3.Download(ctx, "stats/totals.json"); err == nil {
+		if err := json.Unmarshal(data, &totals); err != nil {
+			return fmt.Errorf("parsing stats totals: %w", err)
+		}
+	} else if !isNotFound(err) {
+		return fmt.Errorf("loading stats totals: %w", err)
+	}
+
+	today := time.Now().UTC().Format(time.DateOnly)
+	day, err := loadDayStats(ctx, s3, today)
+	if err != nil {
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.loaded = true
+	// Downloads counted before loading finished are added on top.
+	for key, n := range d.totals {
+		totals[key] += n
+	}
+	d.totals = totals
+	if pending := d.days[today]; pending != nil {
+		day.merge(pending)
+	}
+	if d.days == nil {
+		d.days = map[string]*dayStats{}
+	}
+	d.days[today] = day
+	return nil
+}
+
+func loadDayStats(ctx context.Context, s3 *S3Client, date string) (*dayStats, error) {
+	day := newDayStats()
+	data, err := s3.Download(ctx, "stats/daily/"+date+".json")
+	if isNotFound(err) {
+		return day, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("loading stats for %s: %w", date, err)
+	}
+	if err := json.Unmarshal(data, day); err != nil {
+		return nil, fmt.Errorf("parsing stats for %s: %w", date, err)
+	}
+	return day, nil
+}
+
+// day returns the stats of a date, from memory if it has not been flushed
+// yet and from storage otherwise.
+func (d *downloadStats) day(ctx context.Context, s3 *S3Client, date string) (*dayStats, error) {
+	d.mu.Lock()
+	if day := d.days[date]; day != nil {
+		defer d.mu.Unlock()
+		result := newDayStats()
+		result.merge(day)
+		return result, nil
+	}
+	d.mu.Unlock()
+	return loadDayStats(ctx, s3, date)
+}
+
+func (d *dayStats) merge(o *dayStats) {
+	for key, n := range o.Downloads {
+		d.Downloads[key] += n
+	}
+	for pkg, clients := range o.Clients {
+		if d.Clients[pkg] == nil {
+			d.Clients[pkg] = map[string]bool{}
+		}
+		maps.Copy(d.Clients[pkg], clients)
+	}
+}
+
+// record counts a download of a pool file by a client.
+func (d *downloadStats) record(poolPath, clientIP string) {
+	file := strings.TrimSuffix(path.Base(poolPath), ".deb")
+	pkg, _, ok := strings.Cut(file, "_")
+	if !ok || file == path.Base(poolPath) {
+		return
+	}
+	now := time.Now().UTC()
+	date := now.Format(time.DateOnly)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.totals == nil {
+		d.totals = map[string]int64{}
+		d.days = map[string]*dayStats{}
+	}
+	day := d.days[date]
+	if day == nil {
+		day = newDayStats()
+		d.days[date] = day
+	}
+
+	d.totals[file]++
+	day.Downloads[file]++
+	if day.Clients[pkg] == nil {
+		day.Clients[pkg] = map[string]bool{}
+	}
+	day.Clients[pkg][d.clientHash(date, clientIP)] = true
+	d.dirty = true
+}
+
+// clientHash identifies a client for one day without storing its address.
+func (d *downloadStats) clientHash(date, clientIP string) string {
+	mac := hmac.New(sha256.New, d.salt)
+	mac.Write([]byte(date + "|" + clientIP))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// flush writes changed statistics to storage and forgets past days.
+func (d *downloadStats) flush(ctx context.Context, s3 *S3Client) error {
+	d.mu.Lock()
+	if !d.dirty || !d.loaded {
+		d.mu.Unlock()
+		return nil
+	}
+	uploads := map[string][]byte{}
+	totals, err := json.Marshal(d.totals)
+	if err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	uploads["stats/totals.json"] = totals
+	today := time.Now().UTC().Format(time.DateOnly)
+	for date, day := range d.days {
+		data, err := json.Marshal(day)
+		if err != nil {
+			d.mu.Unlock()
+			return err
+		}
+		uploads["stats/daily/"+date+".json"] = data
+	}
+	d.dirty = false
+	d.mu.Unlock()
+
+	for key, data := range uploads {
+		if err := s3.Upload(ctx, key, data, "application/json"); err != nil {
+			d.mu.Lock()
+			d.dirty = true
+			d.mu.Unlock()
+			return fmt.Errorf("uploading %s: %w", key, err)
+		}
+	}
+
+	// Past days are complete and stored now.
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for date := range d.days {
+		if date < today {
+			delete(d.days, date)
+		}
+	}
+	return nil
+}
+
+// runStats loads the persisted statistics and flushes them periodically
+// until ctx is done. Loading is retried every interval until it succeeds;
+// downloads counted meanwhile are kept in memory. Run flushes once more
+// after the server has stopped.
+func (s *server) runStats(ctx context.Context) {
+	loaded := s.loadStats(ctx)
+
+	ticker := time.NewTicker(statsFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !loaded {
+				loaded = s.loadStats(ctx)
+				continue
+			}
+			if err := s.stats.flush(ctx, s.s3); err != nil {
+				slog.Warn("Failed to store download statistics", "error", err)
+			}
+		}
+	}
+}
+
+func (s *server) loadStats(ctx context.Context) bool {
+	if err := s.stats.load(ctx, s.s3); err != nil {
+		slog.Warn("Failed to load download statistics, retrying later", "error", err)
+		return false
+	}
+	return true
+}
+
+// countDownloads records pool downloads that were served or redirected.
+// Redirects are sent without looking the file up, so they only count for
+// files in the published indexes. Range requests resuming a download are
+// not counted again.
+func (s *server) countDownloads(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if r.Method != http.MethodGet {
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/")
+		if rec.status == http.StatusOK || rec.status == http.StatusFound && s.snapshot.published(key) {
+			s.stats.record(r.URL.Path, s.clientIP(r).String())
+		}
+	})
+}
+
+// packageStats is the download summary of one package.
+type packageStats struct {
+	Package      string           `json:"package"`
+	Downloads    int64            `json:"downloads"`
+	Files        map[string]int64 `json:"files"`         // "<version>_<arch>" -> downloads
+	ClientsToday int              `json:"clients_today"` // unique clients today
+}
+
+// summary aggregates the totals per package, sorted by name.
+func (d *downloadStats) summary() []packageStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	byPackage := map[string]*packageStats{}
+	for file, n := range d.totals {
+		pkg, rest, _ := strings.Cut(file, "_")
+		ps := byPackage[pkg]
+		if ps == nil {
+			ps = &packageStats{Package: pkg, Files: map[string]int64{}}
+			byPackage[pkg] = ps
+		}
+		ps.Downloads += n
+		ps.Files[rest] += n
+	}
+	if today := d.days[time.Now().UTC().Format(time.DateOnly)]; today != nil {
+		for pkg, clients := range today.Clients {
+			if ps := byPackage[pkg]; ps != nil {
+				ps.ClientsToday = len(clients)
+			}
+		}
+	}
+
+	result := make([]packageStats, 0, len(byPackage))
+	for _, pkg := range slices.Sorted(maps.Keys(byPackage)) {
+		result = append(result, *byPackage[pkg])
+	}
+	return result
+}
+
+// dailyStats is the download summary of one day.
+type dailyStats struct {
+	Date      string           `json:"date"`
+	Downloads map[string]int64 `json:"downloads"` // package -> downloads
+	Clients   map[string]int   `json:"clients"`   // package -> unique clients
+}
+
+// handleStats returns the totals per package and the per-day counts of the
+// last "days" days (default 7, at most 90).
+func (s *server) handleStats(w http.ResponseWriter, r *http.Request) {
+	days := 7
+	if v := r.URL.Query().Get("days"); v != "" {
+		if _, err := fmt.Sscanf(v, "%d", &days); err != nil || days < 1 || days > 90 {
+			writeJSONError(w, http.StatusBadRequest, fmt.Errorf("days must be between 1 and 90"))
+			return
+		}
+	}
+
+	var daily []dailyStats
+	for i := range days {
+		date := time.Now().UTC().AddDate(0, 0, -i).Format(time.DateOnly)
+		day, err := s.stats.day(r.Context(), s.s3, date)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err)
+			return
+		}
+		summary := dailyStats{Date: date, Downloads: map[string]int64{}, Clients: map[string]int{}}
+		for file, n := range day.Downloads {
+			pkg, _, _ := strings.Cut(file, "_")
+			summary.Downloads[pkg] += n
+		}
+		for pkg, clients := range day.Clients {
+			summary.Clients[pkg] = len(clients)
+		}
+		daily = append(daily, summary)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"packages": s.stats.summary(),
+		"daily":    daily,
+	})
+}
+
+// statsHTML renders the download totals for the index page.
+func (s *server) statsHTML() string {
+	summary := s.stats.summary()
+	if len(summary) == 0 {
+		return ""
+	}
+
+	var rows strings.Builder
+	for _, ps := range summary {
+		var files []string
+		for _, file := range slices.Sorted(maps.Keys(ps.Files)) {
+			files = append(files, fmt.Sprintf("%s: %d", html.EscapeString(file), ps.Files[file]))
+		}
+		fmt.Fprintf(&rows, "<tr><td><code>%s</code></td><td>%d</td><td>%d</td><td><small>%s</small></td></tr>\n",
+			html.EscapeString(ps.Package), ps.Downloads, ps.ClientsToday, strings.Join(files, ", "))
+	}
+	return `<h2>Downloads</h2>
+<table>
+<tr><th>Package</th><th>Total</th><th>Clients today</th><th>By version and architecture</th></tr>
+` + rows.String() + `</table>
+`
+}
@@ -0,0 +1,93 @@
+package ppa
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// sourceStatus is the outcome of a source's recent polls, persisted to
+// meta/<source>/status so it survives restarts.
+type sourceStatus struct {
+	LastCheck           time.Time `json:"last_check,omitzero"`
+	LastSuccess         time.Time `json:"last_success,omitzero"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastErrorAt         time.Time `json:"last_error_at,omitzero"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Version             string    `json:"version,omitempty"`
+	NextPoll            time.Time `json:"next_poll,omitzero"`
+	Unverified          string    `json:"unverified,omitempty"` // why the last published artifact could not be verified
+}
+
+// loadStatuses reads the persisted status of every registered source.
+func (p *PPA) loadStatuses(ctx context.Context) {
+	for _, reg := range p.sources {
+		name := reg.Source.Name()
+		data, err := p.s3.Download(ctx, "meta/"+name+"/status")
+		if err != nil {
+			if !isNotFound(err) {
+				slog.Warn("Failed to load source status", "source", name, "error", err)
+			}
+			continue
+		}
+		var status sourceStatus
+		if err := json.Unmarshal(data, &status); err != nil {
+			slog.Warn("Failed to parse source status", "source", name, "error", err)
+			continue
+		}
+		p.statusMu.Lock()
+		p.status[name] = status
+		p.statusMu.Unlock()
+	}
+}
+
+// recordPoll updates and persists a source's status after a poll that
+// ended with pollErr.
+func (p *PPA) recordPoll(ctx context.Context, sourceName string, nextPoll time.Time, pollErr error) {
+	p.statusMu.Lock()
+	status := p.status[sourceName]
+	status.LastCheck = time.Now().UTC()
+	status.NextPoll = nextPoll.UTC()
+	if v := p.latestVersion(sourceName); v != "" {
+		status.Version = v
+	}
+	if pollErr != nil {
+		status.LastError = pollErr.Error()
+		status.LastErrorAt = status.LastCheck
+		status.ConsecutiveFailures++
+	} else {
+		status.LastSuccess = status.LastCheck
+		status.ConsecutiveFailures = 0
+	}
+	p.status[sourceName] = status
+	p.statusMu.Unlock()
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		slog.Warn("Failed to encode source status", "source", sourceName, "error", err)
+		return
+	}
+	if err := p.s3.Upload(ctx, "meta/"+sourceName+"/status", data, "application/json"); err != nil {
+		slog.Warn("Failed to store source status", "source", sourceName, "error", err)
+	}
+}
+
+// recordVerification notes why the artifact a source just published could
+// not be verified, or clears the note if unverified is empty. It is
+// persisted with the next recordPoll.
+func (p *PPA) recordVerification(sourceName, unverified string) {
+	p.statusMu.Lock()
+	defer p.statusMu.Unlock()
+	status := p.status[sourceName]
+	status.Unverified = unverified
+	p.status[sourceName] = status
+}
+
+// sourceStatusOf returns the last recorded status of a source.
+func (p *PPA) sourceStatusOf(sourceName string) (sourceStatus, bool) {
+	p.statusMu.RLock()
+	defer p.statusMu.RUnlock()
+	status, ok := p.status[sourceName]
+	return status, ok
+}
@@ -0,0 +1,167 @@
+package ppa
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Transform modifies an unpacked upstream package before it is published.
+// Transforms are listed per source in SourceRegistration and applied in order.
+type Transform func(pkg *DebPackage) error
+
+// applyTransforms unpacks deb, runs transforms over it and repacks it.
+func applyTransforms(deb []byte, transforms []Transform) ([]byte, error) {
+	pkg, err := UnpackDeb(deb)
+	if err != nil {
+		return nil, fmt.Errorf("unpacking .deb: %w", err)
+	}
+	for i, t := range transforms {
+		if err := t(pkg); err != nil {
+			return nil, fmt.Errorf("transform %d: %w", i+1, err)
+		}
+	}
+	return pkg.Build()
+}
+
+// SetField sets or overrides a control field, e.g. to fix a broken Maintainer.
+func SetField(key, value string) Transform {
+	return func(pkg *DebPackage) error {
+		pkg.Control.Set(key, value)
+		return nil
+	}
+}
+
+// AppendDepends adds dependencies to the package's Depends field.
+func AppendDepends(deps ...string) Transform {
+	return func(pkg *DebPackage) error {
+		all := deps
+		if existing := pkg.Control.Get("Depends"); existing != "" {
+			all = append([]string{existing}, deps...)
+		}
+		pkg.Control.Set("Depends", strings.Join(all, ", "))
+		return nil
+	}
+}
+
+// VersionSuffix appends a local suffix such as "+ppa1" to the version so
+// modified packages are distinguishable from upstream builds.
+func VersionSuffix(suffix string) Transform {
+	return func(pkg *DebPackage) error {
+		pkg.Control.Set("Version", pkg.Control.Version+suffix)
+		return nil
+	}
+}
+
+// PrependScript inserts a POSIX shell snippet at the top of a maintainer
+// script (e.g. "postinst"), right after its shebang so a trailing "exit 0"
+// cannot skip it. The script is created if the package has none. Scripts
+// run by another interpreter, such as Perl, are refused. The snippet is
+// preceded by a marker comment, so a script that already has it is left
+// alone.
+func PrependScript(name, snippet string) Transform {
+	sum := sha256.Sum256([]byte(snippet))
+	marker := fmt.Sprintf("# ppa transform %x\n", sum[:8])
+	snippet = marker + snippet
+	return func(pkg *DebPackage) error {
+		script := "#!/bin/sh\nset -e\n" + snippet
+		if existing := pkg.ControlFile(name); existing != nil {
+			if strings.Contains(string(existing.Body), marker) {
+				return nil
+			}
+			shebang, rest, _ := strings.Cut(string(existing.Body), "\n")
+			if !isShellShebang(shebang) {
+				return fmt.Errorf("%s is not a shell script (%q)", name, shebang)
+			}
+			script = shebang + "\n" + snippet + rest
+		}
+		pkg.SetControlFile(DebEntry{Path: name, Body: []byte(script), Mode: 0755})
+		return nil
+	}
+}
+
+// isShellShebang reports whether a script's first line runs it with a
+// POSIX-compatible shell.
+func isShellShebang(line string) bool {
+	interpreter, ok := strings.CutPrefix(line, "#!")
+	if !ok {
+		return false
+	}
+	fields := strings.Fields(interpreter)
+	if len(fields) > 1 && path.Base(fields[0]) == "env" {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return false
+	}
+	switch path.Base(fields[0]) {
+	case "sh", "bash", "dash":
+		return true
+	}
+	return false
+}
+
+// RemoveFiles drops data files whose path matches one of the path.Match
+// patterns (e.g. "/etc/cron.daily/*"), along with their conffiles entries.
+func RemoveFiles(patterns ...string) Transform {
+	matches := func(p string) bool {
+		for _, pattern := range patterns {
+			if ok, _ := path.Match(pattern, p); ok {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(pkg *DebPackage) error {
+		files, err := pkg.Files()
+		if err != nil {
+			return err
+		}
+
+		var kept []DebEntry
+		for _, f := range files {
+			if !f.IsDir && matches(f.Path) {
+				continue
+			}
+			kept = append(kept, f)
+		}
+		pkg.SetFiles(kept)
+
+		if conffiles := pkg.ControlFile("conffiles"); conffiles != nil {
+			var lines []string
+			for _, line := range strings.Split(strings.TrimSpace(string(conffiles.Body)), "\n") {
+				if line != "" && !matches(strings.TrimSpace(line)) {
+					lines = append(lines, line+"\n")
+				}
+			}
+			conffiles.Body = []byte(strings.Join(lines, ""))
+		}
+		return nil
+	}
+}
+
+// AddFiles adds or replaces data files. Parent directories must already
+// exist in the package or be included in entries.
+func AddFiles(entries ...DebEntry) Transform {
+	return func(pkg *DebPackage) error {
+		files, err := pkg.Files()
+		if err != nil {
+			return err
+		}
+
+		replaced := map[string]bool{}
+		for _, e := range entries {
+			replaced[e.Path] = true
+		}
+		var kept []DebEntry
+		for _, f := range files {
+			if !replaced[f.Path] {
+				kept = append(kept, f)
+			}
+		}
+		pkg.SetFiles(append(kept, entries...))
+		return nil
+	}
+}
@@ -0,0 +1,195 @@
+package ppa
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func testControl() DebControl {
+	return DebControl{
+		Package:      "tool",
+		Version:      "1.0-1",
+		Architecture: "amd64",
+		Depends:      "libc6",
+		Fields: []ControlField{
+			{Key: "Package", Value: "tool"},
+			{Key: "Version", Value: "1.0-1"},
+			{Key: "Architecture", Value: "amd64"},
+			{Key: "Maintainer", Value: "Upstream <upstream@example.com>"},
+			{Key: "Depends", Value: "libc6"},
+			{Key: "Description", Value: "Test tool"},
+		},
+	}
+}
+
+func testDeb(t *testing.T, scripts ...DebEntry) []byte {
+	t.Helper()
+	deb, err := BuildDeb(testControl(), []DebEntry{
+		{Path: "/usr", IsDir: true, Mode: 0755},
+		{Path: "/usr/bin", IsDir: true, Mode: 0755},
+		{Path: "/usr/bin/tool", Body: []byte("#!/bin/sh\necho tool\n"), Mode: 0755},
+		{Path: "/usr/bin/t", LinkTarget: "tool", Mode: 0777},
+		{Path: "/etc", IsDir: true, Mode: 0755},
+		{Path: "/etc/cron.daily", IsDir: true, Mode: 0755},
+		{Path: "/etc/cron.daily/tool", Body: []byte("#!/bin/sh\n"), Mode: 0755},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		return deb
+	}
+
+	pkg, err := UnpackDeb(deb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range scripts {
+		pkg.SetControlFile(s)
+	}
+	deb, err = pkg.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return deb
+}
+
+func TestApplyTransformsRoundTrip(t *testing.T) {
+	extra := []byte("extra\n")
+	out, err := applyTransforms(testDeb(t), []Transform{
+		SetField("Maintainer", "PPA <ppa@example.com>"),
+		AppendDepends("libfoo1", "libbar2"),
+		VersionSuffix("+ppa1"),
+		PrependScript("postinst", "echo configured\n"),
+		RemoveFiles("/etc/cron.daily/*"),
+		AddFiles(DebEntry{Path: "/usr/bin/extra", Body: extra, Mode: 0755}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctrl, err := ParseDebControl(bytes.NewReader(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"Package":    "tool",
+		"Version":    "1.0-1+ppa1",
+		"Maintainer": "PPA <ppa@example.com>",
+		"Depends":    "libc6, libfoo1, libbar2",
+	} {
+		if got := ctrl.Get(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+	if ctrl.Version != "1.0-1+ppa1" {
+		t.Errorf("Version field = %q", ctrl.Version)
+	}
+
+	pkg, err := UnpackDeb(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	postinst := pkg.ControlFile("postinst")
+	if postinst == nil {
+		t.Fatal("postinst missing")
+	}
+	if body := string(postinst.Body); !strings.HasPrefix(body, "#!/bin/sh\nset -e\n# ppa transform ") || !strings.HasSuffix(body, "echo configured\n") {
+		t.Errorf("postinst = %q", body)
+	}
+
+	files, err := pkg.Files()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := map[string]DebEntry{}
+	for _, f := range files {
+		paths[f.Path] = f
+	}
+	if _, ok := paths["/etc/cron.daily/tool"]; ok {
+		t.Error("removed file still present")
+	}
+	if _, ok := paths["/etc/cron.daily"]; !ok {
+		t.Error("directory of removed file was removed too")
+	}
+	if f := paths["/usr/bin/t"]; f.LinkTarget != "tool" {
+		t.Errorf("symlink target = %q", f.LinkTarget)
+	}
+	if f := paths["/usr/bin/extra"]; !bytes.Equal(f.Body, extra) {
+		t.Errorf("added file = %q", f.Body)
+	}
+
+	md5sums := pkg.ControlFile("md5sums")
+	if md5sums == nil {
+		t.Fatal("md5sums missing")
+	}
+	want := fmt.Sprintf("%x  usr/bin/extra\n%x  usr/bin/tool\n", md5.Sum(extra), md5.Sum([]byte("#!/bin/sh\necho tool\n")))
+	if got := string(md5sums.Body); got != want {
+		t.Errorf("md5sums = %q, want %q", got, want)
+	}
+}
+
+func TestApplyTransformsKeepsData(t *testing.T) {
+	deb := testDeb(t)
+	out, err := applyTransforms(deb, []Transform{SetField("Section", "devel")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before, err := UnpackDeb(deb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := UnpackDeb(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if after.dataName != before.dataName || !bytes.Equal(after.data, before.data) {
+		t.Error("data archive changed although no transform touched the files")
+	}
+	if after.ControlFile("md5sums") != nil {
+		t.Error("md5sums added although the data archive was kept")
+	}
+}
+
+func TestPrependScript(t *testing.T) {
+	deb := testDeb(t,
+		DebEntry{Path: "postinst", Body: []byte("#!/bin/bash\nconfigure\nexit 0\n"), Mode: 0755},
+		DebEntry{Path: "prerm", Body: []byte("#!/usr/bin/perl\nprint 1;\n"), Mode: 0755},
+	)
+	prepend := PrependScript("postinst", "echo first\n")
+
+	out, err := applyTransforms(deb, []Transform{prepend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := UnpackDeb(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script := string(pkg.ControlFile("postinst").Body)
+	marker, _, _ := strings.Cut(strings.TrimPrefix(script, "#!/bin/bash\n"), "\n")
+	if want := "#!/bin/bash\n" + marker + "\necho first\nconfigure\nexit 0\n"; script != want {
+		t.Errorf("postinst = %q, want %q", script, want)
+	}
+
+	again, err := applyTransforms(out, []Transform{prepend})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err = UnpackDeb(again)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(pkg.ControlFile("postinst").Body); got != script {
+		t.Errorf("applying twice changed postinst to %q", got)
+	}
+
+	if _, err := applyTransforms(deb, []Transform{PrependScript("prerm", "echo no\n")}); err == nil {
+		t.Error("prepending to a Perl script succeeded")
+	}
+}
@@ -0,0 +1,316 @@
+package ppa
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"golang.org/x/crypto/blake2b"
+)
+
+// VerificationError reports that a fetched artifact did not match the
+// checksum or signature published by upstream. Sources wrap integrity
+// failures in it so the poller can refuse to publish and alert.
+type VerificationError struct {
+	Err error
+}
+
+func (e *VerificationError) Error() string {
+	return "upstream verification failed: " + e.Err.Error()
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// VerifySHA256 checks data against an expected hex-encoded SHA256 digest.
+func VerifySHA256(data []byte, expected string) error {
+	expected = strings.ToLower(strings.TrimSpace(expected))
+	sum := sha256.Sum256(data)
+	actual := hex.EncodeToString(sum[:])
+	if actual != expected {
+		return &VerificationError{Err: fmt.Errorf("SHA256 mismatch: expected %s, got %s", expected, actual)}
+	}
+	return nil
+}
+
+// ParseChecksumFile returns the digest listed for filename in a
+// sha256sum-style file ("<hex>  <name>" or "<hex> *<name>" per line).
+func ParseChecksumFile(sums []byte, filename string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		name := strings.TrimPrefix(fields[1], "*")
+		if name == filename || strings.HasSuffix(name, "/"+filename) {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", filename)
+}
+
+// Verification describes what a registered source's upstream artifacts are
+// checked against before publishing. The zero value checks nothing.
+type Verification struct {
+	// SHA256SumsURL is a sha256sum-style listing that must contain the
+	// artifact's digest.
+	SHA256SumsURL string
+
+	// SigningKey, if set, requires a detached signature downloaded from
+	// SignatureURL. The signature covers the checksum listing if there is
+	// one, and the artifact otherwise.
+	SigningKey   SignatureVerifier
+	SignatureURL string
+}
+
+func (v Verification) enabled() bool {
+	return v.SHA256SumsURL != "" || v.SigningKey != nil
+}
+
+// verify checks an artifact against the checksum listing and signature.
+func (v Verification) verify(ctx context.Context, artifact []byte) error {
+	signed := artifact
+	if v.SHA256SumsURL != "" {
+		sums, err := downloadSmall(ctx, v.SHA256SumsURL)
+		if err != nil {
+			return fmt.Errorf("downloading checksums: %w", err)
+		}
+		sum := sha256.Sum256(artifact)
+		if !checksumListed(sums, hex.EncodeToString(sum[:])) {
+			return &VerificationError{Err: fmt.Errorf("SHA256 %x is not listed in %s", sum, v.SHA256SumsURL)}
+		}
+		signed = sums
+	}
+	if v.SigningKey != nil {
+		signature, err := downloadSmall(ctx, v.SignatureURL)
+		if err != nil {
+			return fmt.Errorf("downloading signature: %w", err)
+		}
+		if err := v.SigningKey.Verify(signed, signature); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checksumListed reports whether a sha256sum-style listing contains digest.
+func checksumListed(sums []byte, digest string) bool {
+	for line := range strings.Lines(string(sums)) {
+		if fields := strings.Fields(line); len(fields) > 0 && strings.EqualFold(fields[0], digest) {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadSmall fetches a checksum listing or signature.
+func downloadSmall(ctx context.Context, url string) ([]byte, error) {
+	resp, err := HTTPWithRetry(ctx, url, "GET")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+}
+
+// SignatureVerifier checks detached signatures against a pinned public key.
+type SignatureVerifier interface {
+	// Suffixes returns the file name suffixes of signature files in this
+	// format, such as ".asc" for "<file>.asc".
+	Suffixes() []string
+
+	// Verify checks a detached signature of data.
+	Verify(data, signature []byte) error
+}
+
+// ParseSigningKey parses a pinned public key and returns a verifier for its
+// signature format: an armored OpenPGP key, a minisign public key, or a
+// PEM-encoded ECDSA or Ed25519 public key for cosign blob signatures.
+func ParseSigningKey(key string) (SignatureVerifier, error) {
+	key = strings.TrimSpace(key)
+	switch {
+	case strings.HasPrefix(key, "-----BEGIN PGP PUBLIC KEY BLOCK-----"):
+		keyring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(key))
+		if err != nil {
+			return nil, fmt.Errorf("reading OpenPGP key: %w", err)
+		}
+		return pgpVerifier{keyring: keyring}, nil
+	case strings.HasPrefix(key, "-----BEGIN PUBLIC KEY-----"):
+		return parseCosignKey(key)
+	default:
+		return parseMinisignKey(key)
+	}
+}
+
+type pgpVerifier struct {
+	keyring openpgp.EntityList
+}
+
+func (v pgpVerifier) Suffixes() []string {
+	return []string{".asc", ".sig", ".gpg"}
+}
+
+// Verify accepts armored and binary signatures.
+func (v pgpVerifier) Verify(data, signature []byte) error {
+	var err error
+	if bytes.HasPrefix(bytes.TrimSpace(signature), []byte("-----BEGIN")) {
+		_, err = openpgp.CheckArmoredDetachedSignature(v.keyring, bytes.NewReader(data), bytes.NewReader(signature), nil)
+	} else {
+		_, err = openpgp.CheckDetachedSignature(v.keyring, bytes.NewReader(data), bytes.NewReader(signature), nil)
+	}
+	if err != nil {
+		return &VerificationError{Err: fmt.Errorf("bad signature: %w", err)}
+	}
+	return nil
+}
+
+// minisignVerifier checks minisign signatures, both legacy ("Ed") and
+// prehashed ("ED"), including the signature over the trusted comment.
+type minisignVerifier struct {
+	keyID [8]byte
+	key   ed25519.PublicKey
+}
+
+// parseMinisignKey parses a minisign public key file or just its base64
+// line.
+func parseMinisignKey(key string) (SignatureVerifier, error) {
+	lines := strings.Split(key, "\n")
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[len(lines)-1]))
+	if err != nil || len(raw) != 42 || string(raw[:2]) != "Ed" {
+		return nil, errors.New("unrecognized key: expected an armored OpenPGP key, a minisign public key or a PEM public key")
+	}
+	v := minisignVerifier{key: ed25519.PublicKey(raw[10:])}
+	copy(v.keyID[:], raw[2:10])
+	return v, nil
+}
+
+func (v minisignVerifier) Suffixes() []string {
+	return []string{".minisig"}
+}
+
+func (v minisignVerifier) Verify(data, signature []byte) error {
+	// untrusted comment, signature, trusted comment, global signature
+	lines := strings.Split(strings.TrimSpace(string(signature)), "\n")
+	if len(lines) < 4 {
+		return &VerificationError{Err: errors.New("malformed minisign signature")}
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[1]))
+	if err != nil || len(sig) != 74 {
+		return &VerificationError{Err: errors.New("malformed minisign signature")}
+	}
+	if !bytes.Equal(sig[2:10], v.keyID[:]) {
+		return &VerificationError{Err: errors.New("minisign signature made with a different key")}
+	}
+
+	message := data
+	switch string(sig[:2]) {
+	case "Ed":
+	case "ED":
+		sum := blake2b.Sum512(data)
+		message = sum[:]
+	default:
+		return &VerificationError{Err: fmt.Errorf("unsupported minisign algorithm %q", sig[:2])}
+	}
+	if !ed25519.Verify(v.key, message, sig[10:]) {
+		return &VerificationError{Err: errors.New("bad signature")}
+	}
+
+	comment, ok := strings.CutPrefix(strings.TrimSpace(lines[2]), "trusted comment: ")
+	if !ok {
+		return &VerificationError{Err: errors.New("malformed minisign trusted comment")}
+	}
+	globalSig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(lines[3]))
+	if err != nil || !ed25519.Verify(v.key, slices.Concat(sig[10:], []byte(comment)), globalSig) {
+		return &VerificationError{Err: errors.New("bad signature of the trusted comment")}
+	}
+	return nil
+}
+
+// cosignVerifier checks base64-encoded signatures made by
+// "cosign sign-blob --key", which sign the SHA256 digest of the blob.
+type cosignVerifier struct {
+	key crypto.PublicKey
+}
+
+func parseCosignKey(key string) (SignatureVerifier, error) {
+	block, _ := pem.Decode([]byte(key))
+	if block == nil {
+		return nil, errors.New("reading PEM public key: no PEM block")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading PEM public key: %w", err)
+	}
+	switch pub.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+	return cosignVerifier{key: pub}, nil
+}
+
+func (v cosignVerifier) Suffixes() []string {
+	return []string{".sig"}
+}
+
+func (v cosignVerifier) Verify(data, signature []byte) error {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+	if err != nil {
+		return &VerificationError{Err: fmt.Errorf("decoding cosign signature: %w", err)}
+	}
+	ok := false
+	switch key := v.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, sig)
+	}
+	if !ok {
+		return &VerificationError{Err: errors.New("bad signature")}
+	}
+	return nil
+}
+
+// unverifiedReport collects why a fetched artifact was published without
+// verification. See ReportUnverified.
+type unverifiedReport struct {
+	reason string
+}
+
+type unverifiedReportKey struct{}
+
+func withUnverifiedReport(ctx context.Context) (context.Context, *unverifiedReport) {
+	report := &unverifiedReport{}
+	return context.WithValue(ctx, unverifiedReportKey{}, report), report
+}
+
+// ReportUnverified lets a source's Fetch report that the returned artifact
+// could not be verified because upstream publishes nothing to check it
+// against. The publish goes ahead, but is counted in
+// ppa_unverified_publishes_total and shown in the source's status.
+func ReportUnverified(ctx context.Context, reason string) {
+	if report, ok := ctx.Value(unverifiedReportKey{}).(*unverifiedReport); ok {
+		report.reason = reason
+	}
+}
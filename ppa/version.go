@@ -0,0 +1,169 @@
+package ppa
+
+import (
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	epochPattern    = regexp.MustCompile(`^[0-9]+$`)
+	upstreamPattern = regexp.MustCompile(`^[A-Za-z0-9.+~:-]+$`)
+	revisionPattern = regexp.MustCompile(`^[A-Za-z0-9.+~]+$`)
+)
+
+// Version is a Debian package version: [epoch:]upstream[-revision].
+type Version struct {
+	Epoch    int
+	Upstream string
+	Revision string
+}
+
+// ParseVersion parses and validates a version according to Debian policy.
+// Like dpkg, it only warns about an upstream version not starting with a
+// digit, so an odd upstream version scheme doesn't stop publishing.
+func ParseVersion(s string) (Version, error) {
+	if e, _, ok := strings.Cut(s, ":"); ok && !epochPattern.MatchString(e) {
+		return Version{}, fmt.Errorf("version %q: epoch must be numeric", s)
+	}
+	v := splitVersion(s)
+	if !upstreamPattern.MatchString(v.Upstream) {
+		return Version{}, fmt.Errorf("version %q: upstream version must be non-empty and contain only alphanumerics and .+~:-", s)
+	}
+	if !isDigit(v.Upstream[0]) {
+		slog.Warn("Version does not start with a digit", "version", s)
+	}
+	if strings.Contains(s, "-") && !revisionPattern.MatchString(v.Revision) {
+		return Version{}, fmt.Errorf("version %q: revision must be non-empty and contain only alphanumerics and .+~", s)
+	}
+	return v, nil
+}
+
+// String formats the version, omitting a zero epoch and an empty revision.
+func (v Version) String() string {
+	s := v.Upstream
+	if v.Epoch != 0 {
+		s = strconv.Itoa(v.Epoch) + ":" + s
+	}
+	if v.Revision != "" {
+		s += "-" + v.Revision
+	}
+	return s
+}
+
+// Compare orders v against o the way dpkg does, returning -1, 0 or +1.
+// Epochs compare numerically, then the upstream version and revision compare
+// with dpkg's digit/non-digit rules, where "~" sorts before anything, even
+// the end of the string.
+func (v Version) Compare(o Version) int {
+	if v.Epoch != o.Epoch {
+		if v.Epoch < o.Epoch {
+			return -1
+		}
+		return 1
+	}
+	if c := compareVersionPart(v.Upstream, o.Upstream); c != 0 {
+		return c
+	}
+	return compareVersionPart(v.Revision, o.Revision)
+}
+
+// CompareVersions orders two version strings the way dpkg does, returning
+// -1, 0 or +1. Malformed versions are compared leniently rather than
+// rejected; use ParseVersion to validate.
+func CompareVersions(a, b string) int {
+	return splitVersion(a).Compare(splitVersion(b))
+}
+
+// splitVersion splits "[epoch:]upstream[-revision]" into its parts without
+// validating them.
+func splitVersion(s string) Version {
+	var v Version
+	if e, rest, ok := strings.Cut(s, ":"); ok {
+		v.Epoch, _ = strconv.Atoi(e)
+		s = rest
+	}
+	if i := strings.LastIndex(s, "-"); i >= 0 {
+		v.Upstream, v.Revision = s[:i], s[i+1:]
+		return v
+	}
+	v.Upstream = s
+	return v
+}
+
+// compareVersionPart is dpkg's verrevcmp.
+func compareVersionPart(a, b string) int {
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		// Compare the non-digit prefix character by character.
+		for (i < len(a) && !isDigit(a[i])) || (j < len(b) && !isDigit(b[j])) {
+			ac, bc := charOrder(a, i), charOrder(b, j)
+			if ac != bc {
+				return sign(ac - bc)
+			}
+			i++
+			j++
+		}
+
+		// Compare the digit run numerically, ignoring leading zeros.
+		for i < len(a) && a[i] == '0' {
+			i++
+		}
+		for j < len(b) && b[j] == '0' {
+			j++
+		}
+		firstDiff := 0
+		for i < len(a) && isDigit(a[i]) && j < len(b) && isDigit(b[j]) {
+			if firstDiff == 0 {
+				firstDiff = int(a[i]) - int(b[j])
+			}
+			i++
+			j++
+		}
+		if i < len(a) && isDigit(a[i]) {
+			return 1
+		}
+		if j < len(b) && isDigit(b[j]) {
+			return -1
+		}
+		if firstDiff != 0 {
+			return sign(firstDiff)
+		}
+	}
+	return 0
+}
+
+// charOrder weights s[i] for comparison: "~" lowest, then end of string and
+// digits, then letters, then all other characters.
+func charOrder(s string, i int) int {
+	if i >= len(s) {
+		return 0
+	}
+	c := s[i]
+	switch {
+	case isDigit(c):
+		return 0
+	case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		return int(c)
+	case c == '~':
+		return -1
+	default:
+		return int(c) + 256
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package ppa
+
+import "testing"
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		// Equal versions
+		{"1.0", "1.0", 0},
+		{"1.0-1", "1.0-1", 0},
+		{"0:1.0", "1.0", 0},
+		{"1.01", "1.1", 0},
+		{"1.001-01", "1.1-1", 0},
+		{"1.0~rc1", "1.0~rc1", 0},
+
+		// Numeric comparison of digit runs
+		{"1.0", "1.1", -1},
+		{"1.2", "1.10", -1},
+		{"1.9.9", "1.10", -1},
+		{"2.0", "10.0", -1},
+		{"1.0", "1.0.1", -1},
+		{"1.0.0", "1.0.00", 0},
+
+		// Tilde sorts before everything, even the end of the string
+		{"1.0~rc1", "1.0", -1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0~~", "1.0~", -1},
+		{"1.0~~a", "1.0~", -1},
+		{"1.0~", "1.0", -1},
+		{"1.0-1~bpo1", "1.0-1", -1},
+
+		// Letters sort before non-letters, end of string before letters
+		{"1.0", "1.0a", -1},
+		{"1.0a", "1.0+", -1},
+		{"1.0a", "1.0.", -1},
+		{"1.0+", "1.0.", -1},
+		{"1.0Z", "1.0a", -1},
+		{"1.0+dfsg", "1.0.1", -1},
+		{"1.0a", "1.0b", -1},
+
+		// Epochs win over everything else
+		{"1:0.1", "2.0", 1},
+		{"1:1.0", "2:0.1", -1},
+		{"10:1.0", "9:2.0", 1},
+
+		// Revisions compare after the upstream version
+		{"1.0-1", "1.0-2", -1},
+		{"1.0-2", "1.0-10", -1},
+		{"1.0-1", "1.0-1ubuntu1", -1},
+		{"1.0-1ubuntu1", "1.0-1.1", -1},
+		{"1.0", "1.0-1", -1},
+		{"1.0-0", "1.0", 0},
+		{"1.1-1", "1.0-9", 1},
+
+		// Hyphens within the upstream version; the last one starts the revision
+		{"1.0-beta-1", "1.0-beta-2", -1},
+		{"1.0-beta-1", "1.0-1", 1},
+
+		// Real-world versions
+		{"0.0.50", "0.0.100", -1},
+		{"11.23.3", "11.3.0", 1},
+		{"1.0.0+ppa1", "1.0.0", 1},
+		{"1.0.0+ppa1", "1.0.1", -1},
+	}
+
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := CompareVersions(tt.b, tt.a); got != -tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Version
+		wantErr bool
+	}{
+		{in: "1.0", want: Version{Upstream: "1.0"}},
+		{in: "1.0-1", want: Version{Upstream: "1.0", Revision: "1"}},
+		{in: "2:1.0-1", want: Version{Epoch: 2, Upstream: "1.0", Revision: "1"}},
+		{in: "1.0-beta-1", want: Version{Upstream: "1.0-beta", Revision: "1"}},
+		{in: "1:2.0:3-1", want: Version{Epoch: 1, Upstream: "2.0:3", Revision: "1"}},
+		{in: "1.0~rc1+dfsg-0ubuntu1", want: Version{Upstream: "1.0~rc1+dfsg", Revision: "0ubuntu1"}},
+		// dpkg only warns about upstream versions not starting with a digit.
+		{in: "v1.2.3", want: Version{Upstream: "v1.2.3"}},
+		{in: "a:1.0", wantErr: true},
+		{in: "1.0-", wantErr: true},
+		{in: "-1", wantErr: true},
+		{in: "1.0 beta", wantErr: true},
+		{in: "1.0-1_2", wantErr: true},
+		{in: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseVersion(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseVersion(%q) = %+v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseVersion(%q) error: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseVersion(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+		if s := got.String(); s != tt.in {
+			t.Errorf("ParseVersion(%q).String() = %q", tt.in, s)
+		}
+	}
+}
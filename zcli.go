@@ -5,49 +5,169 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"net/http"
 	"strings"
 
 	"github.com/tikinang/discord-ppa/ppa"
 )
 
+// checksumAssetNames are the release assets searched for a sha256sum-style listing.
+var checksumAssetNames = []string{"SHA256SUMS", "sha256sums.txt", "checksums.txt"}
+
 type ZCLISource struct {
-	githubRepo string // "owner/repo" format
+	githubRepo      string                // "owner/repo" format
+	signingKey      ppa.SignatureVerifier // pinned key for release signatures, optional
+	requireChecksum bool
 }
 
-func NewZCLISource(githubRepo string) *ZCLISource {
-	return &ZCLISource{githubRepo: githubRepo}
+func NewZCLISource(githubRepo string, signingKey ppa.SignatureVerifier, requireChecksum bool) *ZCLISource {
+	return &ZCLISource{githubRepo: githubRepo, signingKey: signingKey, requireChecksum: requireChecksum}
 }
 
 func (z *ZCLISource) Name() string {
 	return "zcli"
 }
 
+func (z *ZCLISource) GitHubRepo() string {
+	return z.githubRepo
+}
+
 func (z *ZCLISource) Description() string {
-	return "Zerops CLI for managing Zerops projects and services. Installs to /usr/local/bin/zcli. The .deb is downloaded directly from GitHub releases of " + z.githubRepo + ". New versions are detected via the GitHub latest release API."
+	return "Zerops CLI for managing Zerops projects and services. Installs to /usr/local/bin/zcli. The .deb is downloaded directly from GitHub releases of " + z.githubRepo + " and verified against the digests and checksums published with the release. New versions are detected via the GitHub latest release API."
 }
 
 func (z *ZCLISource) Check(ctx context.Context) (string, error) {
-	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", z.githubRepo)
-	resp, err := ppa.HTTPWithRetry(ctx, url, "GET")
+	release, err := z.latestRelease(ctx)
 	if err != nil {
-		return "", fmt.Errorf("GitHub API request failed: %w", err)
+		return "", err
 	}
-	defer resp.Body.Close()
+	return release.TagName, nil
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+func (z *ZCLISource) Fetch(ctx context.Context) ([]byte, error) {
+	release, err := z.latestRelease(ctx)
+	if err != nil {
+		return nil, err
 	}
 
-	var release githubRelease
-	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-		return "", fmt.Errorf("decoding GitHub release: %w", err)
+	// Find the amd64 .deb asset
+	var deb, fallback *githubAsset
+	for i, asset := range release.Assets {
+		if strings.HasSuffix(asset.BrowserDownloadURL, "_amd64.deb") {
+			deb = &release.Assets[i]
+			break
+		}
+		if strings.HasSuffix(asset.BrowserDownloadURL, ".deb") && fallback == nil {
+			fallback = &release.Assets[i]
+		}
+	}
+	if deb == nil {
+		deb = fallback
+	}
+	if deb == nil {
+		return nil, fmt.Errorf("no .deb asset found in release %s", release.TagName)
 	}
 
-	return release.TagName, nil
+	data, err := downloadAsset(ctx, deb.BrowserDownloadURL)
+	if err != nil {
+		return nil, fmt.Errorf("downloading .deb: %w", err)
+	}
+
+	if err := z.verify(ctx, release, deb, data); err != nil {
+		return nil, err
+	}
+	return data, nil
 }
 
-func (z *ZCLISource) Fetch(ctx context.Context) ([]byte, error) {
+// verify checks the downloaded .deb against the asset digest reported by the
+// GitHub API, a checksum listing published with the release and, when a
+// signing key is pinned, a detached GPG, minisign or cosign signature over the
+// .deb or the listing.
+func (z *ZCLISource) verify(ctx context.Context, release *githubRelease, deb *githubAsset, data []byte) error {
+	verified := false
+
+	if digest, ok := strings.CutPrefix(deb.Digest, "sha256:"); ok {
+		if err := ppa.VerifySHA256(data, digest); err != nil {
+			return err
+		}
+		verified = true
+	}
+
+	var sums []byte
+	sumsAsset := release.findAsset(append([]string{deb.Name + ".sha256"}, checksumAssetNames...)...)
+	if sumsAsset != nil {
+		var err error
+		sums, err = downloadAsset(ctx, sumsAsset.BrowserDownloadURL)
+		if err != nil {
+			return fmt.Errorf("downloading %s: %w", sumsAsset.Name, err)
+		}
+
+		digest, err := ppa.ParseChecksumFile(sums, deb.Name)
+		if err != nil && sumsAsset.Name == deb.Name+".sha256" {
+			// Single-file checksum assets often contain just the digest.
+			if fields := strings.Fields(string(sums)); len(fields) > 0 {
+				digest, err = fields[0], nil
+			}
+		}
+		if err != nil {
+			return &ppa.VerificationError{Err: fmt.Errorf("%s: %w", sumsAsset.Name, err)}
+		}
+		if err := ppa.VerifySHA256(data, digest); err != nil {
+			return err
+		}
+		verified = true
+	}
+
+	if z.signingKey != nil {
+		if err := z.verifySignature(ctx, release, deb, data, sumsAsset, sums); err != nil {
+			return err
+		}
+		verified = true
+	}
+
+	if !verified {
+		if z.requireChecksum {
+			return &ppa.VerificationError{Err: fmt.Errorf("release %s publishes no digest, checksum or signature for %s", release.TagName, deb.Name)}
+		}
+		slog.Warn("Publishing unverified upstream artifact", "source", z.Name(), "release", release.TagName, "asset", deb.Name)
+		ppa.ReportUnverified(ctx, fmt.Sprintf("release %s publishes no digest, checksum or signature for %s", release.TagName, deb.Name))
+	}
+	return nil
+}
+
+func (z *ZCLISource) verifySignature(ctx context.Context, release *githubRelease, deb *githubAsset, data []byte, sumsAsset *githubAsset, sums []byte) error {
+	signed, signedData := deb, data
+	sig := release.findAsset(signatureNames(deb.Name, z.signingKey.Suffixes())...)
+	if sig == nil && sumsAsset != nil {
+		// A signed checksum listing covers the .deb transitively.
+		signed, signedData = sumsAsset, sums
+		sig = release.findAsset(signatureNames(sumsAsset.Name, z.signingKey.Suffixes())...)
+	}
+	if sig == nil {
+		return &ppa.VerificationError{Err: fmt.Errorf("release %s has no signature for %s", release.TagName, deb.Name)}
+	}
+
+	signature, err := downloadAsset(ctx, sig.BrowserDownloadURL)
+	if err != nil {
+		return fmt.Errorf("downloading %s: %w", sig.Name, err)
+	}
+	if err := z.signingKey.Verify(signedData, signature); err != nil {
+		return fmt.Errorf("%s: %w", signed.Name, err)
+	}
+	return nil
+}
+
+// signatureNames returns the names of signature assets for the named file.
+func signatureNames(name string, suffixes []string) []string {
+	names := make([]string, len(suffixes))
+	for i, suffix := range suffixes {
+		names[i] = name + suffix
+	}
+	return names
+}
+
+func (z *ZCLISource) latestRelease(ctx context.Context) (*githubRelease, error) {
 	url := fmt.Sprintf("https://api.github.com/repos/%s/releases/latest", z.githubRepo)
 	resp, err := ppa.HTTPWithRetry(ctx, url, "GET")
 	if err != nil {
@@ -63,38 +183,23 @@ func (z *ZCLISource) Fetch(ctx context.Context) ([]byte, error) {
 	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
 		return nil, fmt.Errorf("decoding GitHub release: %w", err)
 	}
+	return &release, nil
+}
 
-	// Find the amd64 .deb asset
-	var debURL, fallbackURL string
-	for _, asset := range release.Assets {
-		if strings.HasSuffix(asset.BrowserDownloadURL, "_amd64.deb") {
-			debURL = asset.BrowserDownloadURL
-			break
-		}
-		if strings.HasSuffix(asset.BrowserDownloadURL, ".deb") && fallbackURL == "" {
-			fallbackURL = asset.BrowserDownloadURL
-		}
-	}
-	if debURL == "" {
-		debURL = fallbackURL
-	}
-	if debURL == "" {
-		return nil, fmt.Errorf("no .deb asset found in release %s", release.TagName)
-	}
-
-	debResp, err := ppa.HTTPWithRetry(ctx, debURL, "GET")
+func downloadAsset(ctx context.Context, url string) ([]byte, error) {
+	resp, err := ppa.HTTPWithRetry(ctx, url, "GET")
 	if err != nil {
-		return nil, fmt.Errorf("downloading .deb: %w", err)
+		return nil, err
 	}
-	defer debResp.Body.Close()
+	defer resp.Body.Close()
 
-	if debResp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status %d downloading .deb", debResp.StatusCode)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %d", resp.StatusCode)
 	}
 
-	data, err := io.ReadAll(io.LimitReader(debResp.Body, 512*1024*1024))
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 512*1024*1024))
 	if err != nil {
-		return nil, fmt.Errorf("reading .deb: %w", err)
+		return nil, fmt.Errorf("reading body: %w", err)
 	}
 	return data, nil
 }
@@ -104,7 +209,20 @@ type githubRelease struct {
 	Assets  []githubAsset `json:"assets"`
 }
 
+// findAsset returns the first asset whose name matches one of names, case-insensitively.
+func (r *githubRelease) findAsset(names ...string) *githubAsset {
+	for _, name := range names {
+		for i, asset := range r.Assets {
+			if strings.EqualFold(asset.Name, name) {
+				return &r.Assets[i]
+			}
+		}
+	}
+	return nil
+}
+
 type githubAsset struct {
 	Name               string `json:"name"`
 	BrowserDownloadURL string `json:"browser_download_url"`
+	Digest             string `json:"digest"` // "sha256:<hex>", reported by GitHub for newer uploads
 }